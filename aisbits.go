@@ -0,0 +1,118 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// payloadBits reads fields from the de-armoured payload of an assembled AIS
+// sentence, for the parts of a message go-ais leaves opaque. Reads past the
+// end return zero and set short, so a decoder can read a whole layout and
+// check once.
+type payloadBits struct {
+	values []byte // one six-bit value per payload character
+	n      int    // length in bits, fill bits excluded
+	pos    int
+	short  bool
+}
+
+// newPayloadBits de-armours the payload of a single-sentence (or assembled)
+// !AIVDM/!AIVDO. It reports false for anything else.
+func newPayloadBits(sentence string) (*payloadBits, bool) {
+	if !strings.HasPrefix(sentence, "!") {
+		return nil, false
+	}
+	body := sentence
+	if star := strings.LastIndexByte(body, '*'); star >= 0 {
+		body = body[:star]
+	}
+	fields := strings.Split(body, ",")
+	if len(fields) != 7 {
+		return nil, false
+	}
+	fill, err := strconv.Atoi(fields[6])
+	if err != nil || fill < 0 || fill > 5 {
+		return nil, false
+	}
+	payload := fields[5]
+	b := &payloadBits{values: make([]byte, len(payload)), n: len(payload)*6 - fill}
+	if b.n <= 0 {
+		return nil, false
+	}
+	for i := 0; i < len(payload); i++ {
+		v, ok := sixbitValue(payload[i])
+		if !ok {
+			return nil, false
+		}
+		b.values[i] = v
+	}
+	return b, true
+}
+
+// bit returns bit i (0 = most significant bit of the first character).
+func (b *payloadBits) bit(i int) uint64 {
+	return uint64(b.values[i/6]>>(5-i%6)) & 1
+}
+
+// uint reads an unsigned field of the given width.
+func (b *payloadBits) uint(width int) uint64 {
+	var v uint64
+	for i := 0; i < width; i++ {
+		v <<= 1
+		if b.pos < b.n {
+			v |= b.bit(b.pos)
+		} else {
+			b.short = true
+		}
+		b.pos++
+	}
+	return v
+}
+
+// int reads a two's complement signed field of the given width.
+func (b *payloadBits) int(width int) int64 {
+	v := b.uint(width)
+	if width > 0 && v&(1<<(width-1)) != 0 {
+		return int64(v) - 1<<width
+	}
+	return int64(v)
+}
+
+func (b *payloadBits) bool() bool {
+	return b.uint(1) == 1
+}
+
+// text reads chars six-bit ASCII characters, dropping trailing '@'
+// padding and spaces.
+func (b *payloadBits) text(chars int) string {
+	buf := make([]byte, chars)
+	for i := range buf {
+		c := byte(b.uint(6))
+		if c < 32 {
+			c += 64
+		}
+		buf[i] = c
+	}
+	if at := strings.IndexByte(string(buf), '@'); at >= 0 {
+		buf = buf[:at]
+	}
+	return strings.TrimRight(string(buf), " ")
+}
+
+func (b *payloadBits) skip(width int) {
+	b.uint(width)
+}
+
+// left is the number of unread bits.
+func (b *payloadBits) left() int {
+	return b.n - b.pos
+}
+
+// packed returns all bits, eight to a byte, with the last byte zero padded.
+func (b *payloadBits) packed() []byte {
+	buf := make([]byte, (b.n+7)/8)
+	for i := 0; i < b.n; i++ {
+		buf[i/8] |= byte(b.bit(i) << (7 - i%8))
+	}
+	return buf
+}
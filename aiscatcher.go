@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// aisCatcherMessage is the part of AIS-catcher's JSON output we use. The
+// decoded fields are ignored in favour of the embedded NMEA, so the message
+// goes through the same decoder and merge path as every other input and
+// the vessel record keeps its usual field names.
+type aisCatcherMessage struct {
+	Class       string   `json:"class"`
+	Channel     string   `json:"channel"`
+	SignalPower *float64 `json:"signalpower"`
+	PPM         *float64 `json:"ppm"`
+	RxTime      string   `json:"rxtime"`   // e.g. 20240102150405
+	RxUxTime    float64  `json:"rxuxtime"` // Unix seconds, newer versions only
+	MMSI        int64    `json:"mmsi"`
+	NMEA        []string `json:"nmea"`
+}
+
+// SignalQuality is what AIS-catcher reports about how a message was
+// received. It travels with the sentence and is stored on the vessel record
+// when the message is merged.
+type SignalQuality struct {
+	SignalPower *float64
+	PPM         *float64
+	Channel     string
+}
+
+// aisCatcherEnvelope is the wrapper AIS-catcher's HTTP output posts.
+type aisCatcherEnvelope struct {
+	StationID json.RawMessage     `json:"stationid"`
+	Msgs      []aisCatcherMessage `json:"msgs"`
+}
+
+// parseAISCatcherJSON accepts a single message, an array of messages,
+// newline-delimited messages, or the HTTP envelope. station is the envelope's
+// station ID, if any.
+func parseAISCatcherJSON(data []byte) (msgs []aisCatcherMessage, station string, err error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	for {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err == io.EOF {
+			break
+		} else if err != nil {
+			return msgs, station, err
+		}
+		raw = bytes.TrimSpace(raw)
+		if len(raw) == 0 {
+			continue
+		}
+		if raw[0] == '[' {
+			var batch []aisCatcherMessage
+			if err := json.Unmarshal(raw, &batch); err != nil {
+				return msgs, station, err
+			}
+			msgs = append(msgs, batch...)
+			continue
+		}
+		var envelope aisCatcherEnvelope
+		if err := json.Unmarshal(raw, &envelope); err != nil {
+			return msgs, station, err
+		}
+		if envelope.Msgs != nil {
+			station = strings.Trim(string(envelope.StationID), `"`)
+			msgs = append(msgs, envelope.Msgs...)
+			continue
+		}
+		var msg aisCatcherMessage
+		if err := json.Unmarshal(raw, &msg); err != nil {
+			return msgs, station, err
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, station, nil
+}
+
+// receivedAt returns AIS-catcher's receive time, or the zero time if the
+// message does not carry one.
+func (m aisCatcherMessage) receivedAt() time.Time {
+	if m.RxUxTime > 0 {
+		whole := int64(m.RxUxTime)
+		return time.Unix(whole, int64((m.RxUxTime-float64(whole))*1e9)).UTC()
+	}
+	if t, err := time.Parse("20060102150405", m.RxTime); err == nil {
+		return t.UTC()
+	}
+	return time.Time{}
+}
+
+// ingestAISCatcher feeds AIS-catcher JSON through process, together with the
+// signal quality of each message, and records the per-receiver reception
+// statistics. It returns the number of messages used.
+func ingestAISCatcher(data []byte, source string, process func(rawNmea, source string, signal *SignalQuality)) (int, error) {
+	msgs, station, err := parseAISCatcherJSON(data)
+	if station != "" && station != "null" {
+		source = source + "/" + station
+	}
+	accepted := 0
+	for _, msg := range msgs {
+		if (msg.Class != "" && msg.Class != "AIS") || len(msg.NMEA) == 0 {
+			continue
+		}
+		// Carry the receive time in a tag block so it survives dedupe,
+		// archiving and forwarding like any other timestamped sentence.
+		receivedAt := msg.receivedAt()
+		signal := &SignalQuality{SignalPower: msg.SignalPower, PPM: msg.PPM, Channel: msg.Channel}
+		for _, sentence := range msg.NMEA {
+			line := strings.TrimSpace(sentence)
+			if line == "" {
+				continue
+			}
+			if !receivedAt.IsZero() && !strings.HasPrefix(line, "\\") {
+				line = formatTagBlock("", receivedAt) + line
+			}
+			aisCatcherCounter.AddEvent()
+			totalMessages.Add(1)
+			process(line, source, signal)
+		}
+		recordReceptionQuality(msg, source)
+		accepted++
+	}
+	if err != nil {
+		return accepted, fmt.Errorf("invalid AIS-catcher JSON: %w", err)
+	}
+	return accepted, nil
+}
+
+// recordReceptionQuality adds a message to the per-receiver reception
+// statistics. Duplicates count too: they were still received.
+func recordReceptionQuality(msg aisCatcherMessage, source string) {
+	receptionMutex.Lock()
+	defer receptionMutex.Unlock()
+	stats, ok := receptionStats[source]
+	if !ok {
+		stats = &receptionSamples{}
+		receptionStats[source] = stats
+	}
+	now := time.Now()
+	stats.samples = append(stats.samples, receptionSample{at: now, signalPower: msg.SignalPower, ppm: msg.PPM})
+	stats.pruneLocked(now)
+}
+
+var aisCatcherCounter SlidingWindowCounter
+
+// receptionWindow is the period ReceptionQuality averages over.
+const receptionWindow = time.Minute
+
+type receptionSample struct {
+	at          time.Time
+	signalPower *float64
+	ppm         *float64
+}
+
+type receptionSamples struct {
+	samples []receptionSample
+}
+
+var (
+	receptionMutex sync.Mutex
+	receptionStats = make(map[string]*receptionSamples)
+)
+
+func (rs *receptionSamples) pruneLocked(now time.Time) {
+	cutoff := now.Add(-receptionWindow)
+	i := 0
+	for i < len(rs.samples) && rs.samples[i].at.Before(cutoff) {
+		i++
+	}
+	rs.samples = rs.samples[i:]
+}
+
+// ReceptionQuality summarises one AIS-catcher receiver over the last minute.
+type ReceptionQuality struct {
+	MessagesPerMin     int     `json:"messages_per_min"`
+	AverageSignalPower float64 `json:"average_signal_power"`
+	AveragePPM         float64 `json:"average_ppm"`
+}
+
+// receptionQualityMetrics snapshots reception quality per receiver.
+func receptionQualityMetrics() map[string]ReceptionQuality {
+	receptionMutex.Lock()
+	defer receptionMutex.Unlock()
+	if len(receptionStats) == 0 {
+		return nil
+	}
+	now := time.Now()
+	result := make(map[string]ReceptionQuality, len(receptionStats))
+	for source, stats := range receptionStats {
+		stats.pruneLocked(now)
+		var q ReceptionQuality
+		var powerSum, ppmSum float64
+		var powerCount, ppmCount int
+		for _, sample := range stats.samples {
+			if sample.signalPower != nil {
+				powerSum += *sample.signalPower
+				powerCount++
+			}
+			if sample.ppm != nil {
+				ppmSum += *sample.ppm
+				ppmCount++
+			}
+		}
+		q.MessagesPerMin = len(stats.samples)
+		if powerCount > 0 {
+			q.AverageSignalPower = powerSum / float64(powerCount)
+		}
+		if ppmCount > 0 {
+			q.AveragePPM = ppmSum / float64(ppmCount)
+		}
+		result[source] = q
+	}
+	return result
+}
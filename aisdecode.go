@@ -14,14 +14,15 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 	"math"
 	"reflect"
+	"runtime"
 	"net/url"
         "io"
 	"sort"
 
-	"go.bug.st/serial"
 	"github.com/google/uuid"
 	ais "github.com/BertoldVdb/go-ais"
 	"github.com/BertoldVdb/go-ais/aisnmea"
@@ -47,9 +48,32 @@ type Metrics struct {
 	TotalMessages           int     `json:"total_messages"`
 	SerialMessagesPerMin    float64 `json:"serial_messages_per_min"`
 	UDPMessagesPerMin       float64 `json:"udp_messages_per_min"`
+	TCPMessagesPerSec       float64 `json:"tcp_messages_per_sec"`
+	TCPMessagesPerMin       float64 `json:"tcp_messages_per_min"`
+	AISCatcherMessagesPerSec float64 `json:"aiscatcher_messages_per_sec"`
+	AISCatcherMessagesPerMin float64 `json:"aiscatcher_messages_per_min"`
+	IngestMessagesPerSec    float64 `json:"ingest_messages_per_sec"`
+	IngestMessagesPerMin    float64 `json:"ingest_messages_per_min"`
+	SerialPorts             map[string]SerialPortMetrics `json:"serial_ports,omitempty"`
+	ReceptionQuality        map[string]ReceptionQuality  `json:"reception_quality,omitempty"`
+	SentenceErrors          map[string]map[string]int    `json:"sentence_errors"`        // source -> reason -> count
+	SentenceErrorReasons    map[string]int               `json:"sentence_error_reasons"` // reason -> count over all sources
 	TotalDeduplications     int     `json:"total_deduplications"`
+	DedupeSetSize           int     `json:"dedupe_set_size"`
+	DedupeSources           map[string]DedupeSourceStats `json:"dedupe_sources"` // first vs duplicate per source
+	BaseStationClocks       map[string]ClockStats        `json:"base_station_clocks,omitempty"` // by base station MMSI
+	ReceiverClocks          map[string]ClockStats        `json:"receiver_clocks,omitempty"`     // by station, else source
+	UDPTruncatedLines       int     `json:"udp_truncated_lines"`
+	UDPMalformedLines       int     `json:"udp_malformed_lines"`
+	IncompleteFragments     int     `json:"incomplete_fragments"`
+	OrphanedFragments       int     `json:"orphaned_fragments"`
 	ActiveWebSockets        int     `json:"active_websockets"`
 	ActiveWebSocketRooms    map[string]int `json:"active_websocket_rooms"`
+	TCPServerClients        int     `json:"tcp_server_clients"`
+	TCPServerDropped        int     `json:"tcp_server_dropped"`
+	QueueDepth              int     `json:"queue_depth"`
+	QueueCapacity           int     `json:"queue_capacity"`
+	QueueDropped            int64   `json:"queue_dropped"`
 	NumVesselsClassA        int     `json:"num_vessels_class_a"`
 	NumVesselsClassB        int     `json:"num_vessels_class_b"`
 	NumVesselsAtoN          int     `json:"num_vessels_aton"`
@@ -66,11 +90,19 @@ type TopVessel struct {
 	NumMessages  int    `json:"num_messages"`
 }
 
+// maxUDPDatagramSize is large enough for any datagram, including batched
+// multi-sentence ones.
+const maxUDPDatagramSize = 65535
+
 var (
     	serialCounter 	SlidingWindowCounter
     	udpCounter    	SlidingWindowCounter
-	totalMessages   int
-	dedupeMessages  int
+    	tcpCounter    	SlidingWindowCounter
+	// Counters bumped by the sources and decode workers.
+	totalMessages     atomic.Int64
+	dedupeMessages    atomic.Int64
+	udpTruncatedLines atomic.Int64
+	udpMalformedLines atomic.Int64
 	activeClients   int
 	activeRooms     = make(map[string]int)
 	vesselCounts    = make(map[string]int) // tracks vessels per type (Class A, B, etc.)
@@ -88,6 +120,8 @@ type AISMessage struct {
 	Type      string      `json:"type"`
 	Data      interface{} `json:"data"`
 	Timestamp string      `json:"timestamp"`
+	Station   string      `json:"station,omitempty"` // tag block s: source station
+	Source    string      `json:"source,omitempty"`  // input that heard it, e.g. a serial port label
 }
 
 type Port struct {
@@ -109,11 +143,13 @@ var (
 // Global vessel data map and mutex.
 var (
 	vesselDataMutex sync.Mutex
-	// Each key is a vessel's userid, and the value is the current merged state (as a map).
-	vesselData = make(map[string]map[string]interface{})
+	// Each key is a vessel's userid, and the value is the current merged state.
+	vesselData = make(map[string]*Vessel)
 )
 
-var previousVesselData map[string]map[string]interface{}
+// previousVesselData is the last vessel data sent to clients. It is
+// replaced, never modified, under vesselDataMutex.
+var previousVesselData map[string]*Vessel
 
 var (
     rollingDistances []DataPoint
@@ -130,28 +166,16 @@ var (
 	changeMutex     sync.Mutex
 )
 
+// receiversMutex serialises every load, modify and save of receivers.json,
+// so the handlers and the receiver clock check do not undo each other.
 var receiversMutex sync.Mutex
 
-// Deduplication state: stores messages and their timestamps.
-type dedupeState struct {
-	message   string
-	timestamp time.Time
-}
-
-var (
-	websocketDedupeWindow  []dedupeState
-	websocketDedupeMutex   sync.Mutex
-
-	aggregatorDedupeWindow []dedupeState
-	aggregatorDedupeMutex  sync.Mutex
-)
-
 var vesselHistoryMutex sync.Mutex
 var vesselLastCoordinates = make(map[string]struct{ lat, lon float64 })
 
 var (
     pendingVesselDataMutex sync.Mutex
-    pendingVesselData      = make(map[string]map[string]interface{})
+    pendingVesselData      = make(map[string]*Vessel)
 )
 
 func (sw *SlidingWindowCounter) AddEvent() {
@@ -173,19 +197,17 @@ func calculateVesselCounts() map[string]int {
     vesselDataMutex.Lock()
     defer vesselDataMutex.Unlock()
     for _, vessel := range vesselData {
-        if cls, ok := vessel["AISClass"].(string); ok {
-            switch cls {
-            case "A":
-                counts["Class A"]++
-            case "B":
-                counts["Class B"]++
-            case "AtoN":
-                counts["AtoN"]++
-            case "Base Station":
-                counts["Base Station"]++
-            case "SAR":
-                counts["SAR"]++
-            }
+        switch vessel.AISClass {
+        case "A":
+            counts["Class A"]++
+        case "B":
+            counts["Class B"]++
+        case "AtoN":
+            counts["AtoN"]++
+        case "Base Station":
+            counts["Base Station"]++
+        case "SAR":
+            counts["SAR"]++
         }
     }
     return counts
@@ -193,6 +215,8 @@ func calculateVesselCounts() map[string]int {
 
 func updateReceiver(payload map[string]string, stateDir string) error {
 	receiversPath := filepath.Join(stateDir, "receivers.json")
+	receiversMutex.Lock()
+	defer receiversMutex.Unlock()
 	// Ensure the payload has a non-empty "uuid"
 	uuidStr, ok := payload["uuid"]
 	if !ok || strings.TrimSpace(uuidStr) == "" {
@@ -299,10 +323,20 @@ func (sw *SlidingWindowCounter) Count(duration time.Duration) int {
     return len(eventsCopy) - i
 }
 
-func cleanDedupeWindow(window *[]dedupeState, mutex *sync.Mutex, duration time.Duration) {
-    mutex.Lock()
-    defer mutex.Unlock()
-    *window = filterWindow(*window, time.Now().Add(-duration))
+// stringListFlag collects the values of a flag that may be given more than once.
+type stringListFlag []string
+
+func (f *stringListFlag) String() string {
+    return strings.Join(*f, ",")
+}
+
+func (f *stringListFlag) Set(value string) error {
+    value = strings.TrimSpace(value)
+    if value == "" {
+        return fmt.Errorf("empty value")
+    }
+    *f = append(*f, value)
+    return nil
 }
 
 func isValidURL(urlStr string) bool {
@@ -310,7 +344,13 @@ func isValidURL(urlStr string) bool {
     return err == nil && u.Scheme != "" && u.Host != ""
 }
 
+// loadReceiverCoordinates returns the receiver position: a fresh own-ship fix
+// if there is one (mobile installations), otherwise the static coordinates
+// in myinfo.json.
 func loadReceiverCoordinates(stateDir string) (float64, float64, error) {
+    if lat, lon, ok := ownShipPosition(); ok {
+        return lat, lon, nil
+    }
     myinfoPath := filepath.Join(stateDir, "myinfo.json")
     data, err := os.ReadFile(myinfoPath)
     if err != nil {
@@ -448,11 +488,23 @@ func isValidReceiver(rec map[string]string) bool {
 	return true
 }
 
-// cleanupHistoryFiles scans the "history" directory under baseDir and removes
-// any records older than expireAfter. It writes the valid records to a temporary file
-// and then replaces the original file. If no valid records remain, the file is deleted.
+// cleanupHistoryFiles scans the "history" and "weather" directories under
+// baseDir and removes any records older than expireAfter from the vessel
+// history and weather CSVs, and from the safety message log. Valid records
+// are written to a temporary file that then replaces the original; if none
+// remain, the file is deleted.
 func cleanupHistoryFiles(baseDir string, expireAfter time.Duration) {
-	historyDir := filepath.Join(baseDir, "history")
+	for _, dir := range []string{"history", weatherDirName} {
+		cleanupCSVFiles(filepath.Join(baseDir, dir), expireAfter)
+	}
+	if safetyLog != nil {
+		if err := safetyLog.Prune(expireAfter); err != nil {
+			log.Printf("Error pruning safety message log: %v", err)
+		}
+	}
+}
+
+func cleanupCSVFiles(historyDir string, expireAfter time.Duration) {
 
 	if _, err := os.Stat(historyDir); os.IsNotExist(err) {
 	    // Create the directory including parents if needed
@@ -579,35 +631,6 @@ func fallbackNameForMessageType(msgType string) (string, bool) {
     }
 }
 
-// addMessageType adds the message type from the decoded packet to the vessel state.
-// It ensures the message type is only added once.
-func addMessageType(vessel map[string]interface{}, packet interface{}) {
-    msgType := getMessageTypeName(packet)
-    var mtypes []string
-    // Try to retrieve any existing MessageTypes.
-    if current, ok := vessel["MessageTypes"]; ok {
-        // It might be stored as []string or []interface{}
-        switch arr := current.(type) {
-        case []string:
-            mtypes = arr
-        case []interface{}:
-            for _, v := range arr {
-                if s, ok := v.(string); ok {
-                    mtypes = append(mtypes, s)
-                }
-            }
-        }
-    }
-    // Only add if not already present.
-    for _, mt := range mtypes {
-        if mt == msgType {
-            return
-        }
-    }
-    mtypes = append(mtypes, msgType)
-    vessel["MessageTypes"] = mtypes
-}
-
 func getMessageTypeName(packet interface{}) string {
     t := reflect.TypeOf(packet)
     if t.Kind() == reflect.Ptr {
@@ -746,14 +769,14 @@ func externalLookupCall(vesselID string, lookupURL string, stateDir string) {
     vesselDataMutex.Lock()
     defer vesselDataMutex.Unlock()
     if vessel, exists := vesselData[vesselID]; exists {
-        vessel["Name"] = nameStr
+        vessel.Static.Name = nameStr
         if callSignStr != "" {
-            vessel["CallSign"] = callSignStr
+            vessel.Static.CallSign = callSignStr
         }
         if imageURLStr != "" {
-            vessel["ImageURL"] = imageURLStr
+            vessel.Static.ImageURL = imageURLStr
         }
-        vessel["LastUpdated"] = time.Now().UTC().Format(time.RFC3339Nano)
+        vessel.LastUpdated = time.Now().UTC()
         log.Printf("External lookup updated vessel %s: Name=%s, CallSign=%s, ImageURL=%s", vesselID, nameStr, callSignStr, imageURLStr)
     }
 }
@@ -771,229 +794,30 @@ func haversine(lat1, lon1, lat2, lon2 float64) float64 {
 	return R * c
 }
 
-// isDuplicateWithLock checks for duplicates while holding the given mutex.
-func isDuplicateWithLock(message string, window *[]dedupeState, mutex *sync.Mutex, duration time.Duration) bool {
-	mutex.Lock()
-	defer mutex.Unlock()
-	return isDuplicate(message, *window, duration)
-}
-
-// appendToWindowWithLock appends a deduplication entry while holding the given mutex.
-func appendToWindowWithLock(message string, window *[]dedupeState, mutex *sync.Mutex) {
-	mutex.Lock()
-	defer mutex.Unlock()
-	*window = append(*window, dedupeState{message: message, timestamp: time.Now()})
-}
-
-// mergeMaps merges newData into baseData. Values in newData override those in baseData.
-func mergeMaps(baseData, newData map[string]interface{}, msgType string) map[string]interface{} {
-    if baseData == nil {
-        baseData = make(map[string]interface{})
-    }
-    // Merge all top-level keys from newData, with special logic for "Name".
-    for key, value := range newData {
-        if key == "Name" {
-            incomingName, ok := value.(string)
-            if !ok || strings.TrimSpace(incomingName) == "" {
-     	      continue
-    	    }
-            incomingName = strings.TrimSpace(incomingName)
-            
-            // Determine the effective current name by stripping any NameExtension.
-            effectiveCurrentName := ""
-            if existingName, exists := baseData["Name"].(string); exists && strings.TrimSpace(existingName) != "" {
-                effectiveCurrentName = strings.TrimSpace(existingName)
-                var ext string
-                // Check for a NameExtension in baseData.
-                if e, ok := baseData["NameExtension"].(string); ok && strings.TrimSpace(e) != "" {
-                    ext = strings.TrimSpace(e)
-                }
-                // Also check if newData carries a NameExtension.
-                if e, ok := newData["NameExtension"].(string); ok && strings.TrimSpace(e) != "" {
-                    ext = strings.TrimSpace(e)
-                }
-                if ext != "" && strings.HasSuffix(effectiveCurrentName, ext) {
-                    effectiveCurrentName = strings.TrimSuffix(effectiveCurrentName, ext)
-                    effectiveCurrentName = strings.TrimSpace(effectiveCurrentName)
-                }
-            }
-            // If there's an effective current name that is valid (not "NO NAME")
-            // and it matches the incoming name, then skip updating.
-            if effectiveCurrentName != "" && strings.ToUpper(effectiveCurrentName) != "NO NAME" && effectiveCurrentName == incomingName {
-                continue
-            }
-            baseData["Name"] = incomingName
-        } else {
-            baseData[key] = value
-        }
-    }
-
-    // Elevate selected fields from ReportA while keeping the nested structure.
-    if reportA, ok := newData["ReportA"].(map[string]interface{}); ok {
-        if name, ok := reportA["Name"].(string); ok && strings.TrimSpace(name) != "" {
-            if existingName, exists := baseData["Name"].(string); !exists ||
-                strings.TrimSpace(existingName) == "" ||
-                strings.ToUpper(strings.TrimSpace(existingName)) == "NO NAME" ||
-                strings.TrimSpace(existingName) != strings.TrimSpace(name) {
-                baseData["Name"] = strings.TrimSpace(name)
-            }
-        }
-    }
-
-    // Continue with other merging logic (e.g. ReportB, AISClass, etc.).
-    if reportB, ok := newData["ReportB"].(map[string]interface{}); ok {
-        if cs, ok := reportB["CallSign"].(string); ok && strings.TrimSpace(cs) != "" {
-            baseData["CallSign"] = cs
-        }
-        if dim, ok := reportB["Dimension"]; ok {
-            baseData["Dimension"] = dim
-        }
-        if fixType, ok := reportB["FixType"]; ok {
-            baseData["FixType"] = fixType
-        }
-        if shipType, ok := reportB["ShipType"]; ok {
-            if shipTypeFloat, ok := shipType.(float64); ok {
-                if shipTypeFloat != 0 || baseData["Type"] == nil {
-                    baseData["Type"] = shipType
-                }
-            } else if shipTypeStr, ok := shipType.(string); ok {
-                if shipTypeStr != "0" || baseData["Type"] == nil {
-                    baseData["Type"] = shipType
-                }
-            } else {
-                baseData["Type"] = shipType
-            }
-        }
-    }
-    
-    // Set AISClass based on message type.
-    switch msgType {
-    case "ShipStaticData", "PositionReport":
-        // Class A messages: mark vessel as Class A.
-        baseData["AISClass"] = "A"
-    case "AidsToNavigationReport":
-        baseData["AISClass"] = "AtoN"
-    case "BaseStationReport":
-        baseData["AISClass"] = "Base Station"
-    case "StandardSearchAndRescueAircraftReport":
-        baseData["AISClass"] = "SAR"
-    default:
-        // Default to Class B if no class has been set.
-        if _, ok := baseData["AISClass"]; !ok {
-            baseData["AISClass"] = "B"
-        }
-    }
-
-    // Handle NameExtension: if present, append it if not already there.
-    if ext, ok := baseData["NameExtension"].(string); ok && strings.TrimSpace(ext) != "" {
-        ext = strings.TrimSpace(ext)
-        if name, ok := baseData["Name"].(string); ok && strings.TrimSpace(name) != "" {
-            if !strings.HasSuffix(name, ext) {
-                baseData["Name"] = name + ext
-            }
-        } else {
-            baseData["Name"] = ext
-        }
-        delete(baseData, "NameExtension")
-    }
-    
-    // Fallback logic: if current Name is "NO NAME", then use fallback value based on msgType.
-    if name, ok := baseData["Name"].(string); ok && strings.ToUpper(strings.TrimSpace(name)) == "NO NAME" {
-        if fallback, valid := fallbackNameForMessageType(msgType); valid {
-            baseData["Name"] = fallback
-        }
-    }
-    
-    return baseData
-}
-
-// filterCompleteVesselData filters vessels that have all required fields.
-func filterCompleteVesselData(vesselData map[string]map[string]interface{}) map[string]map[string]interface{} {
-    filteredData := make(map[string]map[string]interface{})
-    for id, vesselInfo := range vesselData {
-        // Create a deep copy of the nested vesselInfo map.
-        copyInfo := make(map[string]interface{})
-        for k, v := range vesselInfo {
-            copyInfo[k] = v
-        }
-        // Validate Latitude and Longitude from the copied map.
-        lat, latOk := copyInfo["Latitude"].(float64)
-        lon, lonOk := copyInfo["Longitude"].(float64)
-        if !latOk || !lonOk || lat < -90 || lat > 90 || lon < -180 || lon > 180 {
-            continue
-        }
-        // Set defaults if missing.
-        if copyInfo["CallSign"] == nil {
-            copyInfo["CallSign"] = "NO CALL"
-        }
-        if name, ok := copyInfo["Name"].(string); !ok || strings.TrimSpace(name) == "" {
-            copyInfo["Name"] = "NO NAME"
-        }
-        filteredData[id] = copyInfo
-    }
-    return filteredData
-}
-
-
-// isInterfaceMapEqual compares two maps recursively.
-func isInterfaceMapEqual(a, b map[string]interface{}) bool {
-	if len(a) != len(b) {
-		return false
-	}
-	for k, vA := range a {
-		vB, ok := b[k]
-		if !ok || !compareValues(vA, vB) {
-			return false
-		}
-	}
-	return true
-}
-
-// compareValues helps compare two interface{} values.
-// compareValues helps compare two interface{} values.
-func compareValues(currentValue, previousValue interface{}) bool {
-	switch currentTyped := currentValue.(type) {
-	case map[string]interface{}:
-		previousTyped, ok := previousValue.(map[string]interface{})
-		if !ok {
-			return false
-		}
-		return isInterfaceMapEqual(currentTyped, previousTyped)
-	case []interface{}:
-		previousTyped, ok := previousValue.([]interface{})
-		if !ok {
-			return false
-		}
-		if len(currentTyped) != len(previousTyped) {
-			return false
-		}
-		for i := range currentTyped {
-			if !compareValues(currentTyped[i], previousTyped[i]) {
-				return false
-			}
-		}
-		return true
-	case []string:
-		previousTyped, ok := previousValue.([]string)
-		if !ok {
-			return false
+// filterCompleteVesselData returns copies of the vessels that have a valid
+// position, with placeholder Name and CallSign filled in.
+func filterCompleteVesselData(vesselData map[string]*Vessel) map[string]*Vessel {
+	filteredData := make(map[string]*Vessel)
+	for id, vesselInfo := range vesselData {
+		d := vesselInfo.Dynamic
+		if d.Latitude == nil || d.Longitude == nil {
+			continue
 		}
-		if len(currentTyped) != len(previousTyped) {
-			return false
+		copyInfo := vesselInfo.clone()
+		// Set defaults if missing.
+		if copyInfo.Static.CallSign == "" {
+			copyInfo.Static.CallSign = "NO CALL"
 		}
-		for i := range currentTyped {
-			if currentTyped[i] != previousTyped[i] {
-				return false
-			}
+		if strings.TrimSpace(copyInfo.Static.Name) == "" {
+			copyInfo.Static.Name = "NO NAME"
 		}
-		return true
-	default:
-		return currentValue == previousValue
+		filteredData[id] = copyInfo
 	}
+	return filteredData
 }
 
 // isDataChanged compares currentData and previousData.
-func isDataChanged(currentData, previousData map[string]map[string]interface{}) bool {
+func isDataChanged(currentData, previousData map[string]*Vessel) bool {
 	if len(currentData) != len(previousData) {
 		return true
 	}
@@ -1002,82 +826,60 @@ func isDataChanged(currentData, previousData map[string]map[string]interface{})
 		if !exists {
 			return true
 		}
-		if !isInterfaceMapEqual(currentVessel, previousVessel) {
+		if !reflect.DeepEqual(currentVessel, previousVessel) {
 			return true
 		}
 	}
 	return false
 }
 
-func deepCopyVesselData(original map[string]map[string]interface{}) map[string]map[string]interface{} {
-	copy := make(map[string]map[string]interface{})
+func deepCopyVesselData(original map[string]*Vessel) map[string]*Vessel {
+	copy := make(map[string]*Vessel)
 	for id, vesselInfo := range original {
-		newInfo := make(map[string]interface{})
-		for k, v := range vesselInfo {
-			newInfo[k] = v
-		}
-		copy[id] = newInfo
+		copy[id] = vesselInfo.clone()
 	}
 	return copy
 }
 
-// isDuplicate checks if a message is a duplicate within the deduplication window.
-func isDuplicate(message string, dedupeWindow []dedupeState, windowDuration time.Duration) bool {
-	message = strings.TrimSpace(message)
-	now := time.Now()
-	dedupeWindow = filterWindow(dedupeWindow, now.Add(-windowDuration))
-	for _, state := range dedupeWindow {
-		if state.message == message && now.Sub(state.timestamp) < windowDuration {
-			return true // Duplicate found
+// splitDatagram splits a UDP datagram into individual NMEA sentences. Many SDR
+// decoders batch several lines (or every part of a multipart message) into one
+// datagram. It also reports how many lines were truncated or malformed; those
+// lines are not returned. If bufferFull is set, the last line is considered
+// truncated unless it carries a complete checksum.
+func splitDatagram(data []byte, bufferFull bool) (lines []string, truncated, malformed int) {
+	parts := strings.FieldsFunc(string(data), func(r rune) bool {
+		return r == '\r' || r == '\n'
+	})
+	for i, part := range parts {
+		line := strings.TrimSpace(part)
+		if line == "" {
+			continue
 		}
-	}
-	return false
-}
-
-// filterWindow filters deduplication states to those newer than cutoff.
-func filterWindow(window []dedupeState, cutoff time.Time) []dedupeState {
-	filtered := []dedupeState{}
-	for _, state := range window {
-		if state.timestamp.After(cutoff) {
-			filtered = append(filtered, state)
+		if line[0] != '!' && line[0] != '$' && line[0] != '\\' {
+			malformed++
+			continue
 		}
+		if !hasNMEAChecksumField(line) {
+			if bufferFull && i == len(parts)-1 {
+				truncated++
+			} else {
+				malformed++
+			}
+			continue
+		}
+		lines = append(lines, line)
 	}
-	return filtered
+	return lines, truncated, malformed
 }
 
-func cleanInvalidData(data map[string]interface{}) {
-    // Clean TrueHeading: remove if set to 511.
-    if th, ok := data["TrueHeading"].(float64); ok && th == 511 {
-        delete(data, "TrueHeading")
-    }
-    // Clean Cog: remove if set to 360.
-    if cog, ok := data["Cog"].(float64); ok && cog == 360 {
-        delete(data, "Cog")
-    }
-    // Validate Latitude.
-    if latVal, exists := data["Latitude"]; exists {
-        if lat, ok := latVal.(float64); ok {
-            // Remove if latitude is outside the valid range.
-            if lat < -90 || lat > 90 {
-                delete(data, "Latitude")
-            }
-        } else {
-            // If not a valid float (for example an empty string), remove it.
-            delete(data, "Latitude")
-        }
-    }
-    // Validate Longitude.
-    if lonVal, exists := data["Longitude"]; exists {
-        if lon, ok := lonVal.(float64); ok {
-            // Remove if longitude is outside the valid range.
-            if lon < -180 || lon > 180 {
-                delete(data, "Longitude")
-            }
-        } else {
-            // If not a valid float (for example an empty string), remove it.
-            delete(data, "Longitude")
-        }
-    }
+// hasNMEAChecksumField reports whether a sentence ends in a *hh checksum field.
+func hasNMEAChecksumField(line string) bool {
+	star := strings.LastIndexByte(line, '*')
+	if star < 0 || len(line)-star != 3 {
+		return false
+	}
+	_, err := strconv.ParseUint(line[star+1:], 16, 8)
+	return err == nil
 }
 
 // appendHistory appends a new history record for the given vessel.
@@ -1178,31 +980,10 @@ func pushReceiverFiles(stateDir, aggregatorPublicURL string) {
 	pushFile("metrics", metricsFilePath)
 }
 
-func filterVesselSummary(vessels map[string]map[string]interface{}) map[string]map[string]interface{} {
-	summary := make(map[string]map[string]interface{})
-	// List of keys to include in the summary.
+func filterVesselSummary(vessels map[string]*Vessel) map[string]VesselSummary {
+	summary := make(map[string]VesselSummary)
 	for id, v := range vessels {
-		summary[id] = map[string]interface{}{
-			"UserID":               v["UserID"],
-			"Name":                 v["Name"],
-			"CallSign":             v["CallSign"],
-			"ImageURL":		v["ImageURL"],
-			"LastUpdated":          v["LastUpdated"],
-			"NumMessages":          v["NumMessages"],
-			"Destination":          v["Destination"],
-			"Sog":                  v["Sog"],
-			"Cog":                  v["Cog"],
-			"Type":                 v["Type"],
-			"Dimension": 		v["Dimension"],
-			"MaximumStaticDraught": v["MaximumStaticDraught"],
-			"NavigationalStatus":   v["NavigationalStatus"],
-			"Latitude":   		v["Latitude"],
-			"Longitude":  		v["Longitude"],
-			"TrueHeading":  	v["TrueHeading"],
-			"AISClass":             v["AISClass"],
-			"MID":                  v["MID"],
-			"MessageTypes":		v["MessageTypes"],
-		}
+		summary[id] = v.summary()
 	}
 	return summary
 }
@@ -1211,7 +992,9 @@ func main() {
 	startTime := time.Now()
 	// Command-line flags.
 	serialPort := flag.String("serial-port", "", "Serial port device (optional)")
-	baud := flag.Int("baud", 38400, "Baud rate (default: 38400), ignored if -serial-port is not specified")
+	baud := flag.Int("baud", 38400, "Baud rate (default: 38400), also the default for -serial ports")
+	var serialSpecs stringListFlag
+	flag.Var(&serialSpecs, "serial", "Labelled serial port as name=/dev/ttyUSB0,baud=38400 (repeatable, optional)")
 	wsPort := flag.Int("ws-port", 8100, "WebSocket port (default: 8100)")
 	webRoot := flag.String("web-root", "web", "Web root directory (default: web)")
 	debug := flag.Bool("debug", false, "Enable debug output")
@@ -1219,6 +1002,7 @@ func main() {
 	aggregator := flag.String("aggregator", "", "Comma delimited list of aggregator host/ip:port (optional)")
 	udpListenPort := flag.Int("udp-listen-port", 8101, "UDP listen port for incoming NMEA data (default: 8101)")
 	dedupeWindowDuration := flag.Int("dedupe-window", 1000, "Deduplication window in milliseconds (default: 1000, set to 0 to disable deduplication)")
+	dedupeMode := flag.String("dedupe-mode", dedupeModeSentence, "Deduplication key: sentence (whole sentence) or payload (message bits only, ignoring channel, sequence ID and fill bits)")
 	dumpVesselData := flag.Bool("dump-vessel-data", false, "Log the latest vessel data to the screen whenever it is updated")
 	updateInterval := flag.Int("update-interval", 10, "Update interval in seconds for emitting latest vessel data (default: 10)")
 	expireAfter := flag.Duration("expire-after", 24*time.Hour, "Expire vessel data if no update is received within this duration (default: 24h)")
@@ -1229,8 +1013,53 @@ func main() {
 	allowAllUUIDs := flag.Bool("allow-all-uuids", false, "If specified, allows all receiver UUIDs (by default, UUIDs are restricted via allowed list)")
 	logAllDecodesDir := flag.String("log-all-decodes", "", "Directory path to log every decoded message (optional)")
 	aggregatorUploadPeriod := flag.Int("aggregator-upload-period", 1, "Aggregator upload period in minutes (default: 1, 0 disables periodic uploads)")
+	aggregatorTagBlocks := flag.Bool("aggregator-tag-blocks", false, "Prefix sentences forwarded to aggregators with an NMEA 4.10 tag block (s: station name or UUID, c: receive time)")
+	var tcpSources stringListFlag
+	flag.Var(&tcpSources, "tcp-source", "Remote NMEA TCP feed host:port to connect to (repeatable, optional)")
+	aisCatcherUDPPort := flag.Int("aiscatcher-udp-port", 0, "UDP listen port for AIS-catcher JSON output (default: 0, disabled; JSON can also be POSTed to /aiscatcher with a receiver UUID as basic auth user)")
+	tcpServerPort := flag.Int("tcp-server-port", 0, "TCP port to re-serve the deduplicated NMEA feed on (default: 0, disabled)")
+	workers := flag.Int("workers", runtime.NumCPU(), "Number of decode workers (default: number of CPUs)")
+	queueSize := flag.Int("queue-size", 10000, "Sentences that may wait for a decode worker before input is dropped (default: 10000)")
+	fragmentTimeout := flag.Duration("fragment-timeout", 5*time.Second, "Discard incomplete multipart messages after this duration (default: 5s)")
+	replayFile := flag.String("replay", "", "Replay a recorded NMEA log file instead of reading live inputs (optional)")
+	replaySpeed := flag.Float64("replay-speed", 1, "Replay speed multiplier (default: 1, 0 replays as fast as possible)")
+	rawArchiveDir := flag.String("raw-archive-dir", "", "Directory to record every raw received sentence into hourly, gzip-compressed files (optional, ignored with -replay)")
+	rawArchiveRetention := flag.Duration("raw-archive-retention", 7*24*time.Hour, "Remove raw archive files older than this duration (default: 168h, 0 keeps them forever)")
+	receiverClockSkew := flag.Duration("receiver-clock-skew", 0, "Flag receivers in receivers.json whose clock is off by more than this duration, measured against base stations (optional, e.g. 2s)")
 
 	flag.Parse()
+
+	if *replayFile != "" {
+		// Replay mode reproduces a recording; live inputs would mix in current traffic.
+		log.Printf("Replay mode: ignoring serial, UDP and TCP inputs")
+		*serialPort = ""
+		// Replayed sentences would land in past hours and overwrite the
+		// recordings they came from.
+		if *rawArchiveDir != "" {
+			log.Printf("Replay mode: not recording to raw archive directory %s", *rawArchiveDir)
+			*rawArchiveDir = ""
+		}
+		serialSpecs = nil
+		tcpSources = nil
+	}
+
+	// -serial-port keeps working as a single port labelled "Serial".
+	var serialConfigs []serialPortConfig
+	if *serialPort != "" {
+		serialConfigs = append(serialConfigs, serialPortConfig{Label: "Serial", Device: *serialPort, Baud: *baud})
+	}
+	for _, spec := range serialSpecs {
+		cfg, err := parseSerialSpec(spec, *baud)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		for _, existing := range serialConfigs {
+			if existing.Label == cfg.Label {
+				log.Fatalf("Duplicate serial port label %q", cfg.Label)
+			}
+		}
+		serialConfigs = append(serialConfigs, cfg)
+	}
 	
 	if *stateDir != "" {
   	   if err := os.MkdirAll(*stateDir, 0755); err != nil {
@@ -1274,7 +1103,7 @@ func main() {
 
 	if !*noState {
 	    if _, err := os.Stat(statePath); os.IsNotExist(err) {
- 	      emptyState := map[string]map[string]interface{}{}
+ 	      emptyState := map[string]*Vessel{}
  	      data, err := json.MarshalIndent(emptyState, "", "  ")
 	       if err != nil {
 	           log.Fatalf("Error marshaling empty state: %v", err)
@@ -1293,6 +1122,15 @@ func main() {
 	    log.Printf("Logging all decodes to directory: %s", *logAllDecodesDir)
 	}
 
+	if *rawArchiveDir != "" {
+	    archive, err := newRawArchive(*rawArchiveDir, *rawArchiveRetention)
+	    if err != nil {
+	        log.Fatalf("Failed to create raw archive directory %s: %v", *rawArchiveDir, err)
+	    }
+	    rawArchive = archive
+	    log.Printf("Recording raw sentences to directory: %s", *rawArchiveDir)
+	}
+
 	var historyBase string
 	if *stateDir != "" {
   	    historyBase = *stateDir
@@ -1300,6 +1138,21 @@ func main() {
 	    historyBase = *webRoot
 	}
 
+	if *receiverClockSkew > 0 && !*noState && *stateDir != "" {
+	    scheduleReceiverClockCheck(*stateDir, *receiverClockSkew)
+	}
+
+	safetyLogPath := ""
+	if !*noState {
+	    safetyLogPath = filepath.Join(historyBase, "safety_messages.jsonl")
+	}
+	if sl, err := openSafetyLog(safetyLogPath); err != nil {
+	    log.Fatalf("Failed to load safety message log %s: %v", safetyLogPath, err)
+	} else {
+	    safetyLog = sl
+	}
+
+	// The cleanup also prunes the safety message log, so it runs once that is loaded.
 	if !*noState {
 		cleanupHistoryFiles(historyBase, *expireAfter)
 		scheduleDailyCleanup(historyBase, *expireAfter)
@@ -1392,7 +1245,7 @@ func main() {
 	 }
 
 	// Initialize previous vessel data.
-	previousVesselData = make(map[string]map[string]interface{})
+	previousVesselData = make(map[string]*Vessel)
 
 	// Load state from statePath unless state persistence is disabled.
 	if !*noState {
@@ -1401,7 +1254,7 @@ func main() {
 			if err != nil {
 				log.Printf("Error reading state file %s: %v", statePath, err)
 			} else {
-				var loadedData map[string]map[string]interface{}
+				var loadedData map[string]*Vessel
 				if err := json.Unmarshal(data, &loadedData); err != nil {
 					log.Printf("Invalid JSON in state file %s: %v", statePath, err)
 				} else {
@@ -1641,7 +1494,7 @@ func main() {
 	    }
 
 	    // Create a summary for the specific vessel.
-	    vesselSummary := filterVesselSummary(map[string]map[string]interface{}{userID: vessel})
+	    vesselSummary := filterVesselSummary(map[string]*Vessel{userID: vessel})
 	    w.Header().Set("Content-Type", "application/json")
 	    if err := json.NewEncoder(w).Encode(vesselSummary[userID]); err != nil {
 	        http.Error(w, "Error encoding JSON", http.StatusInternalServerError)
@@ -1663,7 +1516,7 @@ func main() {
 	        http.Error(w, "Invalid hours parameter", http.StatusBadRequest)
 	        return
 	    }
-	    cutoffTime := time.Now().UTC().Add(-time.Duration(hours) * time.Hour)
+	    cutoffTime := clockNow().Add(-time.Duration(hours) * time.Hour)
 
 	    // Build the file path to the vessel's history CSV.
 	    filePath := filepath.Join(historyBase, "history", userID+".csv")
@@ -1714,6 +1567,111 @@ func main() {
 	    }
 	})
 
+	http.HandleFunc("/weather/", func(w http.ResponseWriter, r *http.Request) {
+	    // URL should be /weather/<userid>/<hours>; /weather/ alone returns
+	    // the latest observation of every station.
+	    path := strings.TrimPrefix(r.URL.Path, "/weather/")
+	    if path == "" {
+	        w.Header().Set("Content-Type", "application/json")
+	        if err := json.NewEncoder(w).Encode(latestWeather()); err != nil {
+	            http.Error(w, "Error encoding JSON", http.StatusInternalServerError)
+	        }
+	        return
+	    }
+	    parts := strings.Split(path, "/")
+	    if len(parts) != 2 {
+	        http.Error(w, "Invalid URL. Expected format: /weather/<userid>/<hours>", http.StatusBadRequest)
+	        return
+	    }
+	    userID := parts[0]
+	    hours, err := strconv.Atoi(parts[1])
+	    if err != nil {
+	        http.Error(w, "Invalid hours parameter", http.StatusBadRequest)
+	        return
+	    }
+	    cutoffTime := clockNow().Add(-time.Duration(hours) * time.Hour)
+
+	    filePath := filepath.Join(historyBase, weatherDirName, userID+".csv")
+	    f, err := os.Open(filePath)
+	    if err != nil {
+	        http.Error(w, "Weather file not found", http.StatusNotFound)
+	        return
+	    }
+	    defer f.Close()
+
+	    w.Header().Set("Content-Type", "text/csv")
+	    fmt.Fprintln(w, strings.Join(weatherColumns, ","))
+	    scanner := bufio.NewScanner(f)
+	    for scanner.Scan() {
+	        line := scanner.Text()
+	        comma := strings.IndexByte(line, ',')
+	        if comma < 0 {
+	            continue
+	        }
+	        ts, err := time.Parse(time.RFC3339Nano, line[:comma])
+	        if err != nil || ts.Before(cutoffTime) {
+	            continue
+	        }
+	        fmt.Fprintln(w, line)
+	    }
+	    if err := scanner.Err(); err != nil {
+	        http.Error(w, "Error reading weather file", http.StatusInternalServerError)
+	        return
+	    }
+	})
+
+	http.HandleFunc("/safety-messages", func(w http.ResponseWriter, r *http.Request) {
+	    // Optional query parameters: mmsi (sender or addressee), q (text
+	    // contains), hours (received within) and limit (default 100).
+	    query := SafetyQuery{Text: r.URL.Query().Get("q"), Limit: 100}
+	    if v := r.URL.Query().Get("mmsi"); v != "" {
+	        mmsi, err := strconv.ParseUint(v, 10, 32)
+	        if err != nil {
+	            http.Error(w, "Invalid mmsi parameter", http.StatusBadRequest)
+	            return
+	        }
+	        query.MMSI = uint32(mmsi)
+	    }
+	    if v := r.URL.Query().Get("hours"); v != "" {
+	        hours, err := strconv.Atoi(v)
+	        if err != nil {
+	            http.Error(w, "Invalid hours parameter", http.StatusBadRequest)
+	            return
+	        }
+	        query.Since = clockNow().Add(-time.Duration(hours) * time.Hour)
+	    }
+	    if v := r.URL.Query().Get("limit"); v != "" {
+	        limit, err := strconv.Atoi(v)
+	        if err != nil || limit < 0 {
+	            http.Error(w, "Invalid limit parameter", http.StatusBadRequest)
+	            return
+	        }
+	        query.Limit = limit
+	    }
+	    w.Header().Set("Content-Type", "application/json")
+	    if err := json.NewEncoder(w).Encode(safetyLog.Search(query)); err != nil {
+	        http.Error(w, "Error encoding JSON", http.StatusInternalServerError)
+	    }
+	})
+
+	http.HandleFunc("/vdl", func(w http.ResponseWriter, r *http.Request) {
+	    // Optional query parameter mmsi keeps only messages sent by or
+	    // addressed to that station.
+	    var mmsi uint32
+	    if v := r.URL.Query().Get("mmsi"); v != "" {
+	        n, err := strconv.ParseUint(v, 10, 32)
+	        if err != nil {
+	            http.Error(w, "Invalid mmsi parameter", http.StatusBadRequest)
+	            return
+	        }
+	        mmsi = uint32(n)
+	    }
+	    w.Header().Set("Content-Type", "application/json")
+	    if err := json.NewEncoder(w).Encode(vdlState.Snapshot(clockNow(), mmsi)); err != nil {
+	        http.Error(w, "Error encoding JSON", http.StatusInternalServerError)
+	    }
+	})
+
 	http.HandleFunc("/receivers", func(w http.ResponseWriter, r *http.Request) {
 	    // Ensure state persistence is enabled.
 	    if *noState {
@@ -1782,7 +1740,9 @@ func main() {
 	    
 		case "GET":
 			// Load receivers from the receivers.json file.
+			receiversMutex.Lock()
 			receivers, err := loadReceivers(receiversPath)
+			receiversMutex.Unlock()
 			if err != nil && !os.IsNotExist(err) {
 				http.Error(w, "Error reading receivers", http.StatusInternalServerError)
 				return
@@ -1808,6 +1768,11 @@ func main() {
 
 					// Add LastUpdated field based on the file's modification time.
 					localReceiver["LastUpdated"] = time.Now().UTC().Format(time.RFC3339Nano)
+					// A moving installation reports where it is now.
+					if lat, lon, ok := ownShipPosition(); ok {
+						localReceiver["latitude"] = strconv.FormatFloat(lat, 'f', 6, 64)
+						localReceiver["longitude"] = strconv.FormatFloat(lon, 'f', 6, 64)
+					}
 	
 					out = append(out, localReceiver)
 				} else {
@@ -1873,7 +1838,9 @@ func main() {
 
 		        // Load receivers from the state directory.
 		        receiversPath := filepath.Join(*stateDir, "receivers.json")
+		        receiversMutex.Lock()
 		        receivers, err := loadReceivers(receiversPath)
+		        receiversMutex.Unlock()
 		        if err != nil {
 		            http.Error(w, "Error reading receivers: "+err.Error(), http.StatusInternalServerError)
 		            return
@@ -2000,6 +1967,8 @@ func main() {
 		        }
 
 			receiversPath := filepath.Join(*stateDir, "receivers.json")
+			receiversMutex.Lock()
+			defer receiversMutex.Unlock()
 			receivers, err := loadReceivers(receiversPath)
 			if err != nil && !os.IsNotExist(err) {
 				http.Error(w, "Error reading receivers data", http.StatusInternalServerError)
@@ -2088,6 +2057,20 @@ func main() {
 		    }
 		})
 
+		// Handle /ownship endpoint: the installation's own position from
+		// !AIVDO reports and GPS fixes on a serial port.
+		http.HandleFunc("/ownship", func(w http.ResponseWriter, r *http.Request) {
+		    own, ok := ownShipSnapshot()
+		    if !ok {
+		        http.Error(w, "No own-ship position received", http.StatusNotFound)
+		        return
+		    }
+		    w.Header().Set("Content-Type", "application/json")
+		    if err := json.NewEncoder(w).Encode(own); err != nil {
+		        http.Error(w, "Error encoding JSON", http.StatusInternalServerError)
+		    }
+		})
+
 		http.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
 	        	// Determine which directory to use for state.
  	        	var metricsFilePath string
@@ -2102,6 +2085,17 @@ func main() {
 		                return
 			}
 
+		        // Serial port status is live state, not an aggregate, so add it on the way out.
+		        if serialPorts := serialPortMetrics(); serialPorts != nil {
+		                var payload map[string]interface{}
+		                if err := json.Unmarshal(data, &payload); err == nil {
+		                        payload["serial_ports"] = serialPorts
+		                        if merged, err := json.Marshal(payload); err == nil {
+		                                data = merged
+		                        }
+		                }
+		        }
+
 		        w.Header().Set("Content-Type", "application/json")
 		        w.Write(data)
 	       })
@@ -2413,7 +2407,9 @@ func main() {
 		    switch r.Method {
 		    case http.MethodGet:
 		        // Load and return all receivers.
+		        receiversMutex.Lock()
 		        receivers, err := loadReceivers(receiversPath)
+		        receiversMutex.Unlock()
 		        if err != nil && !os.IsNotExist(err) {
 		            http.Error(w, "Error reading receivers: "+err.Error(), http.StatusInternalServerError)
 		            return
@@ -2473,6 +2469,8 @@ func main() {
 		            http.Error(w, "Missing uuid in payload", http.StatusBadRequest)
 		            return
 		        }
+		        receiversMutex.Lock()
+		        defer receiversMutex.Unlock()
 		        receivers, err := loadReceivers(receiversPath)
 		        if err != nil && !os.IsNotExist(err) {
 		            http.Error(w, "Error loading receivers: "+err.Error(), http.StatusInternalServerError)
@@ -2519,6 +2517,8 @@ func main() {
 		            http.Error(w, "Missing uuid in payload", http.StatusBadRequest)
 		            return
 		        }
+		        receiversMutex.Lock()
+		        defer receiversMutex.Unlock()
 		        receivers, err := loadReceivers(receiversPath)
 		        if err != nil && !os.IsNotExist(err) {
 		            http.Error(w, "Error loading receivers: "+err.Error(), http.StatusInternalServerError)
@@ -2559,21 +2559,6 @@ func main() {
 		}
 	}()
 
-	// --- Setup AIS decoder ---
-	var port serial.Port
-	if *serialPort != "" {
-		mode := &serial.Mode{BaudRate: *baud}
-		var err error
-		port, err = serial.Open(*serialPort, mode)
-		if err != nil {
-			log.Fatalf("failed to open serial port: %v", err)
-		}
-		defer port.Close()
-	}
-	codec := ais.CodecNew(false, false)
-	codec.DropSpace = true
-	nmeaCodec := aisnmea.NMEACodecNew(codec)
-
 	// Setup UDP aggregator if needed.
 	var aggregatorConns []*net.UDPConn
 	if *aggregator != "" {
@@ -2610,300 +2595,173 @@ func main() {
 	}
 
 	windowDuration := time.Duration(*dedupeWindowDuration) * time.Millisecond
-
-	// --- Start UDP listener for incoming NMEA data ---
-	udpAddrStr := fmt.Sprintf(":%d", *udpListenPort)
-	udpListener, err := net.ListenPacket("udp", udpAddrStr)
-	if err != nil {
-		log.Fatalf("Error starting UDP listener: %v", err)
+	if *dedupeMode != dedupeModeSentence && *dedupeMode != dedupeModePayload {
+		log.Fatalf("Invalid -dedupe-mode %q: must be %s or %s", *dedupeMode, dedupeModeSentence, dedupeModePayload)
 	}
-	defer udpListener.Close()
 
-	go func() {
-	    buf := make([]byte, 1024)
-  	    for {
-		n, addr, err := udpListener.ReadFrom(buf)
-		if err != nil {
-			log.Printf("Error reading UDP message: %v", err)
-			continue
-		}
-		udpCounter.AddEvent()
-		totalMessages++
-		rawNmea := string(buf[:n])
-		currentTime := time.Now().UTC().Format(time.RFC3339Nano)
-		source := addr.String()
-		if *debug {
-			log.Printf("[DEBUG] Received from UDP (%s) at %s: %s", source, currentTime, rawNmea)
-		}
-		// Check deduplication before processing.
-		if *dedupeWindowDuration > 0 && isDuplicateWithLock(rawNmea, &aggregatorDedupeWindow, &aggregatorDedupeMutex, windowDuration) {
-			if *debug {
-				log.Printf("[DEBUG] Dropped duplicate message from %s at %s: %s", source, currentTime, rawNmea)
+	fragmentAssembler.SetTimeout(*fragmentTimeout)
+
+	// --- Start TCP NMEA server for downstream plotters ---
+	if *tcpServerPort > 0 {
+		nmeaServer = newNMEAServer()
+		go func() {
+			if err := nmeaServer.ListenAndServe(*tcpServerPort); err != nil {
+				log.Fatalf("NMEA TCP server error: %v", err)
 			}
-			dedupeMessages++
-			continue
-		}
-		appendToWindowWithLock(rawNmea, &aggregatorDedupeWindow, &aggregatorDedupeMutex)
+		}()
+	}
+
+	// --- Shared processing stage: every input feeds it, every output hangs off it ---
+	pipeline = newPipeline(func() *aisnmea.NMEACodec {
+		codec := ais.CodecNew(false, false)
+		codec.DropSpace = true
+		return aisnmea.NMEACodecNew(codec)
+	}, *workers, *queueSize)
+	pipeline.Debug = *debug
+	pipeline.ShowDecodes = *showDecodes
+	pipeline.DedupeWindow = windowDuration
+	pipeline.DedupeMode = *dedupeMode
+	dedupeSet.SetWindow(windowDuration)
+	pipeline.ExpireAfter = *expireAfter
+	pipeline.ExternalLookupURL = *externalLookupURL
+	pipeline.StateDir = *stateDir
+	if nmeaServer != nil {
+		pipeline.AddSink(nmeaServer)
+	}
+	if len(aggregatorConns) > 0 {
+		pipeline.AddSink(&aggregatorSink{conns: aggregatorConns, tagBlocks: *aggregatorTagBlocks, stateDir: *stateDir, debug: *debug})
+	}
+	if *logAllDecodesDir != "" {
+		pipeline.AddSink(&decodeLogSink{dir: *logAllDecodesDir})
+	}
+	pipeline.AddSink(&socketIOSink{server: sioServer})
+	pipeline.AddSink(&historySink{baseDir: historyBase, noState: *noState, stateDir: *stateDir})
+	pipeline.AddSink(&weatherSink{baseDir: historyBase, noState: *noState, server: sioServer})
+	pipeline.AddSink(&safetySink{server: sioServer})
+	pipeline.AddSink(&clockSink{})
+	pipeline.AddSink(&vdlSink{})
 
-		decoded, err := nmeaCodec.ParseSentence(rawNmea)
+	// --- Start UDP listener for incoming NMEA data ---
+	if *replayFile == "" {
+		udpAddrStr := fmt.Sprintf(":%d", *udpListenPort)
+		udpListener, err := net.ListenPacket("udp", udpAddrStr)
 		if err != nil {
-		    log.Printf("Error decoding sentence: %v", err)
-		    continue
-		}
-		if decoded == nil || decoded.Packet == nil {
-		    continue
+			log.Fatalf("Error starting UDP listener: %v", err)
 		}
+		defer udpListener.Close()
+		go pipeline.Run(&udpSource{conn: udpListener, debug: *debug})
+	}
 
-		if *logAllDecodesDir != "" {
-		    logDecodedMessage(decoded.Packet, *logAllDecodesDir)
+	// --- AIS-catcher JSON input, over UDP and HTTP POST ---
+	if *replayFile == "" && *aisCatcherUDPPort > 0 {
+		aisCatcherListener, err := net.ListenPacket("udp", fmt.Sprintf(":%d", *aisCatcherUDPPort))
+		if err != nil {
+			log.Fatalf("Error starting AIS-catcher UDP listener: %v", err)
 		}
+		defer aisCatcherListener.Close()
+		log.Printf("Listening for AIS-catcher JSON on UDP port %d", *aisCatcherUDPPort)
 
-		// Convert the decoded packet to a map for cleaning.
-		var newData map[string]interface{}
-		{
-		    b, err := json.Marshal(decoded.Packet)
-		    if err != nil {
-		        log.Printf("Error marshaling AIS packet: %v", err)
-		        continue
-		    }
-		    if err := json.Unmarshal(b, &newData); err != nil {
-		        log.Printf("Error unmarshaling AIS packet to map: %v", err)
-		        continue
-		    }
+		go func() {
+			buf := make([]byte, maxUDPDatagramSize)
+			for {
+				n, addr, err := aisCatcherListener.ReadFrom(buf)
+				if err != nil {
+					log.Printf("Error reading AIS-catcher UDP message: %v", err)
+					continue
+				}
+				if _, err := ingestAISCatcher(buf[:n], addr.String(), pipeline.ProcessWithSignal); err != nil && *debug {
+					log.Printf("[DEBUG] AIS-catcher datagram from %s: %v", addr, err)
+				}
+			}
+		}()
+	}
+	http.HandleFunc("/aiscatcher", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+			return
 		}
-
-		// Clean the invalid data before constructing the final message.
-		cleanInvalidData(newData)
-
-		// Build the AIS message with cleaned data.
-		typeName := getMessageTypeName(decoded.Packet)
-		aisMsg := AISMessage{
-		    Type:      typeName,
-		    Data:      newData,
-		    Timestamp: time.Now().UTC().Format(time.RFC3339Nano),
+		// Same receiver authentication as /ingest: the UUID as basic auth user.
+		if _, err := ingestReceiverUUID(r, *stateDir, *allowAllUUIDs); err != nil {
+			w.Header().Set("WWW-Authenticate", `Basic realm="AIS-catcher"`)
+			http.Error(w, "Unauthorized: "+err.Error(), http.StatusUnauthorized)
+			return
 		}
-		finalMsg, err := json.Marshal(aisMsg)
+		body, err := io.ReadAll(io.LimitReader(r.Body, 16<<20))
 		if err != nil {
-		    log.Printf("Error marshaling AISMessage: %v", err)
-		    continue
+			http.Error(w, "Error reading request body", http.StatusBadRequest)
+			return
 		}
-		if *showDecodes {
-		    log.Println("Decoded AIS Packet:", string(finalMsg))
+		source := r.RemoteAddr
+		if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+			source = host
 		}
+		accepted, err := ingestAISCatcher(body, source, pipeline.ProcessWithSignal)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]int{"accepted": accepted})
+	})
 
-		// Use the same newData to extract the UserID.
-		userIDFloat, ok := newData["UserID"].(float64)
-		if !ok {
-		    availableKeys := make([]string, 0, len(newData))
-		    for key := range newData {
-		        availableKeys = append(availableKeys, key)
-		    }
-		    log.Printf("Vessel packet missing or invalid UserID field. Available keys: %v", availableKeys)
-		    continue
+	// --- HTTP POST ingest for sites that cannot send UDP ---
+	http.HandleFunc("/ingest", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+			return
 		}
-		vesselID := fmt.Sprintf("%.0f", userIDFloat)
-		var MID int
-		if len(vesselID) >= 3 {
-		    MID, _ = strconv.Atoi(vesselID[:3])
-		} else {
-		    MID, _ = strconv.Atoi(vesselID)
+		receiverUUID, err := ingestReceiverUUID(r, *stateDir, *allowAllUUIDs)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Basic realm="Ingest"`)
+			http.Error(w, "Unauthorized: "+err.Error(), http.StatusUnauthorized)
+			return
 		}
-		newData["MID"] = MID
-
-		roomName := "ais_data/" + vesselID
-		if err := sioServer.To(socket.Room(roomName)).Emit("ais_data", string(finalMsg)); err != nil {
-		    log.Printf("Error sending decoded AIS data to room %s: %v", roomName, err)
+		lines, err := readIngestBatch(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
 		}
-
-
-			// Forward to aggregator if enabled.
-			if len(aggregatorConns) > 0 {
-			    for _, conn := range aggregatorConns {
-			        if _, err := conn.Write([]byte(rawNmea)); err != nil {
- 				   if *debug {
-				        log.Printf("[DEBUG] Error sending raw NMEA sentence over UDP to aggregator: %v", err)
-				    }
+		// Treat the batch like UDP input from this receiver, counted apart.
+		source := "ingest/" + receiverUUID
+		accepted, rejected := 0, 0
+		for _, line := range lines {
+			if reason, ok := ingestRejectReason(line); !ok {
+				if reason != "" {
+					recordSentenceError(source, reason)
 				}
-			    }
-			}
-
-			// Now record the message in the aggregator deduplication window.
-			aggregatorDedupeWindow = append(aggregatorDedupeWindow, dedupeState{message: rawNmea, timestamp: time.Now()})
-
-			// Process vessel data update.
-			vesselDataMutex.Lock()
-
-			cleanInvalidData(newData)
-
-			msgType := getMessageTypeName(decoded.Packet)
-			merged := mergeMaps(vesselData[vesselID], newData, msgType)
-			merged["LastUpdated"] = time.Now().UTC().Format(time.RFC3339Nano)
-			addMessageType(merged, decoded.Packet)
-			// Get current time
-			now := time.Now().UTC()
-
-			// Lock the timestamp map for the current vessel.
-			vesselMsgTimestampsMutex.Lock()
-			vesselMsgTimestamps[vesselID] = append(vesselMsgTimestamps[vesselID], now)
-			
-			// Remove timestamps older than expire-after.
-			cutoff := now.Add(-*expireAfter)
-			validTimestamps := vesselMsgTimestamps[vesselID][:0]
-			for _, t := range vesselMsgTimestamps[vesselID] {
-			    if t.After(cutoff) {
-			        validTimestamps = append(validTimestamps, t)
-			    }
-			}
-			vesselMsgTimestamps[vesselID] = validTimestamps
-			vesselMsgTimestampsMutex.Unlock()
-
-			// Set rolling total for NumMessages.
-			merged["NumMessages"] = float64(len(validTimestamps))
-			vesselData[vesselID] = merged
-			vesselDataMutex.Unlock()
-
-			if *externalLookupURL != "" {
-			    vesselDataMutex.Lock()
-			    _, exists := vesselData[vesselID]
-			    vesselDataMutex.Unlock()
-			
-			    if !exists {
-			        go externalLookupCall(vesselID, *externalLookupURL, *stateDir)
-			    } else {
-			        name, ok := merged["Name"].(string)
-			        if !ok || strings.TrimSpace(name) == "" || name == "NO NAME" {
-			            go externalLookupCall(vesselID, *externalLookupURL, *stateDir)
-			        }
-			    }
-			}
-
-			// Append to vessel history only if lat/lon have changed by an acceptable amount.
-			if lat, ok := merged["Latitude"].(float64); ok {
-   			 if lon, ok := merged["Longitude"].(float64); ok {
-			        vesselHistoryMutex.Lock()
-			        last, exists := vesselLastCoordinates[vesselID]
-			        distance := 0.0
-			        if exists {
-			            distance = haversine(last.lat, last.lon, lat, lon)
-			        }
-			
-			        // Check for spurious jump: if the distance is greater than 10 km.
-			        if exists && distance > 10000.0 {
-			            pendingVesselDataMutex.Lock()
-			            if pending, found := pendingVesselData[vesselID]; found {
-			                // Compare new reading to the already pending one.
-			                pLat, ok1 := pending["Latitude"].(float64)
-			                pLon, ok2 := pending["Longitude"].(float64)
-			                if ok1 && ok2 {
-			                    pendingDistance := haversine(pLat, pLon, lat, lon)
-			                    if pendingDistance <= 10000.0 {
-			                        // The new reading is close enough to the pending update.
-			                        // Commit the pending update to the vessel's current state.
-			                        vesselDataMutex.Lock()
-			                        vesselData[vesselID] = pending
-			                        vesselDataMutex.Unlock()
-			                        // Update the baseline coordinate.
-			                        vesselLastCoordinates[vesselID] = struct{ lat, lon float64 }{pLat, pLon}
-			
-			                        // Append the pending update to history.
-			                        ts := pending["LastUpdated"].(string)
-			                        var sogStr, cogStr, trueHeadingStr string
-			                        if sog, ok := pending["Sog"].(float64); ok {
-			                            sogStr = fmt.Sprintf("%.2f", sog)
-				                        }
-			                        if cog, ok := pending["Cog"].(float64); ok {
-			                            cogStr = fmt.Sprintf("%.2f", cog)
-			                        }
-			                        if th, ok := pending["TrueHeading"].(float64); ok {
-			                            trueHeadingStr = fmt.Sprintf("%.2f", th)
-			                        }
-			                        if !*noState {
-			                            if err := appendHistory(historyBase, vesselID, pLat, pLon, sogStr, cogStr, trueHeadingStr, ts); err != nil {
-			                                log.Printf("Error appending history for vessel %s: %v", vesselID, err)
-			                            }
-			                        }
-			                        // Remove the pending update.
-			                        delete(pendingVesselData, vesselID)
-			                    } else {
-			                        // The new update is still far from the pending one; update the pending update.
-			                        pendingVesselData[vesselID] = merged
-			                    }
-			                }
-			            } else {
-			                // No pending update exists yet—store this spurious reading.
-			                pendingVesselData[vesselID] = merged
-			            }
-			            pendingVesselDataMutex.Unlock()
-			            vesselHistoryMutex.Unlock()
-			            // Do not update the current state with this spurious reading.
-			            continue
-			        }
-			
-			        // For very small movements (<10 m), keep the current behavior.
-			        if exists && distance < 10.0 {
-			            vesselLastCoordinates[vesselID] = struct{ lat, lon float64 }{lat, lon}
-				    if receiverLat, receiverLon, err := loadReceiverCoordinates(*stateDir); err == nil {
-     					  updateDistanceMetrics(lat, lon, receiverLat, receiverLon)
-				    }
-			            vesselHistoryMutex.Unlock()
-			            continue
-			        }
-			
-			        // Otherwise, the update is within acceptable bounds.
-			        // Commit the update normally.
-			        ts := merged["LastUpdated"].(string)
-			        var sogStr, cogStr, trueHeadingStr string
-			        if sog, ok := merged["Sog"].(float64); ok {
-			            sogStr = fmt.Sprintf("%.2f", sog)
-			        }
-			        if cog, ok := merged["Cog"].(float64); ok {
-			            cogStr = fmt.Sprintf("%.2f", cog)
-			        }
-			        if th, ok := merged["TrueHeading"].(float64); ok {
-			            trueHeadingStr = fmt.Sprintf("%.2f", th)
-			        }
-			
-			        // Append the valid update to history.
-			        if !*noState {
-			            if err := appendHistory(historyBase, vesselID, lat, lon, sogStr, cogStr, trueHeadingStr, ts); err != nil {
-			                log.Printf("Error appending history for vessel %s: %v", vesselID, err)
-			            }
-			        }
-			        // Update the baseline coordinate for future comparisons.
-			        vesselLastCoordinates[vesselID] = struct{ lat, lon float64 }{lat, lon}
-				if receiverLat, receiverLon, err := loadReceiverCoordinates(*stateDir); err == nil {
-     				    updateDistanceMetrics(lat, lon, receiverLat, receiverLon)
-  			        } 
-			        vesselHistoryMutex.Unlock()
-			    }
-			}
-						
-			vesselDataMutex.Lock()
-			latestData := filterCompleteVesselData(vesselData)
-			vesselDataMutex.Unlock()
-			if !isDataChanged(latestData, previousVesselData) {
+				rejected++
 				continue
 			}
-			changeMutex.Lock()
-			changeAvailable = true
-			changeMutex.Unlock()
+			ingestCounter.AddEvent()
+			totalMessages.Add(1)
+			pipeline.Process(line, source)
+			accepted++
 		}
-	}()
+		if *debug {
+			log.Printf("[DEBUG] Ingest from %s: %d accepted, %d rejected", receiverUUID, accepted, rejected)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]int{"accepted": accepted, "rejected": rejected})
+	})
+
+	// --- Replay a recorded log through the same pipeline ---
+	if *replayFile != "" {
+		go pipeline.Run(&replaySource{path: *replayFile, speed: *replaySpeed})
+	}
+
+	// --- Connect to remote TCP NMEA feeds ---
+	for _, addr := range tcpSources {
+		go pipeline.Run(&tcpSource{addr: addr, debug: *debug})
+	}
 
 	go func() {
 		ticker := time.NewTicker(time.Duration(*updateInterval) * time.Second)
 		for range ticker.C {
 			// Remove vessels that haven't updated within expireAfter.
 			vesselDataMutex.Lock()
-			now := time.Now().UTC()
+			now := clockNow()
 			for id, vessel := range vesselData {
-				lastUpdatedStr, ok := vessel["LastUpdated"].(string)
-				if !ok {
-					delete(vesselData, id)
-					continue
-				}
-				t, err := time.Parse(time.RFC3339Nano, lastUpdatedStr)
-				if err != nil || now.Sub(t) > *expireAfter {
+				if vessel.LastUpdated.IsZero() || now.Sub(vessel.LastUpdated) > *expireAfter {
 					delete(vesselData, id)
 				}
 			}
@@ -2942,7 +2800,10 @@ func main() {
 					}
 				}
 			
-				previousVesselData = deepCopyVesselData(latestData)
+				sent := deepCopyVesselData(latestData)
+				vesselDataMutex.Lock()
+				previousVesselData = sent
+				vesselDataMutex.Unlock()
 				if *dumpVesselData {
 					indentJSON, err := json.MarshalIndent(latestData, "", "  ")
 					if err != nil {
@@ -2959,14 +2820,6 @@ func main() {
 
 
 
-	go func() {
-	    ticker := time.NewTicker(1 * time.Minute)
-	    defer ticker.Stop()
-	    for range ticker.C {
-	        cleanDedupeWindow(&aggregatorDedupeWindow, &aggregatorDedupeMutex, windowDuration)
-	        cleanDedupeWindow(&websocketDedupeWindow, &websocketDedupeMutex, windowDuration)
-	    }
-	}()
 
 go func() {
     ticker := time.NewTicker(1 * time.Second)
@@ -3003,14 +2856,39 @@ go func() {
             avg = 0
         }
 
+        tcpServerClients, tcpServerDropped := nmeaServer.Stats()
+        incompleteFragments, orphanedFragments := fragmentAssembler.Stats()
+        sentenceErrorsBySource, sentenceErrorsByReason := sentenceErrorMetrics()
+        queueDepth, queueCapacity, queueDropped := pipeline.QueueStats()
+        dedupeSetSize, dedupeSources := dedupeSet.Stats()
+        baseStationClocks, receiverClocks := clockMetrics()
+
         // Build the metrics payload
         metrics := Metrics{
             SerialMessagesPerSec:    float64(serialCounter.Count(1 * time.Second)),
             SerialMessagesPerMin:    float64(serialCounter.Count(1 * time.Minute)),
             UDPMessagesPerSec:       float64(udpCounter.Count(1 * time.Second)),
             UDPMessagesPerMin:       float64(udpCounter.Count(1 * time.Minute)),
-            TotalMessages:           totalMessages,
-            TotalDeduplications:     dedupeMessages,
+            TCPMessagesPerSec:       float64(tcpCounter.Count(1 * time.Second)),
+            TCPMessagesPerMin:       float64(tcpCounter.Count(1 * time.Minute)),
+            AISCatcherMessagesPerSec: float64(aisCatcherCounter.Count(1 * time.Second)),
+            AISCatcherMessagesPerMin: float64(aisCatcherCounter.Count(1 * time.Minute)),
+            IngestMessagesPerSec:    float64(ingestCounter.Count(1 * time.Second)),
+            IngestMessagesPerMin:    float64(ingestCounter.Count(1 * time.Minute)),
+            SerialPorts:             serialPortMetrics(),
+            ReceptionQuality:        receptionQualityMetrics(),
+            SentenceErrors:          sentenceErrorsBySource,
+            SentenceErrorReasons:    sentenceErrorsByReason,
+            TotalMessages:           int(totalMessages.Load()),
+            TotalDeduplications:     int(dedupeMessages.Load()),
+            DedupeSetSize:           dedupeSetSize,
+            DedupeSources:           dedupeSources,
+            BaseStationClocks:       baseStationClocks,
+            ReceiverClocks:          receiverClocks,
+            UDPTruncatedLines:       int(udpTruncatedLines.Load()),
+            UDPMalformedLines:       int(udpMalformedLines.Load()),
+            IncompleteFragments:     incompleteFragments,
+            OrphanedFragments:       orphanedFragments,
             ActiveWebSockets:        len(clients),
             ActiveWebSocketRooms:    func() map[string]int {
                                          roomsMutex.Lock()
@@ -3022,6 +2900,11 @@ go func() {
                                          }
                                          return roomsCopy
                                      }(),
+            TCPServerClients:        tcpServerClients,
+            TCPServerDropped:        tcpServerDropped,
+            QueueDepth:              queueDepth,
+            QueueCapacity:           queueCapacity,
+            QueueDropped:            queueDropped,
             NumVesselsClassA:        calculateVesselCounts()["Class A"],
             NumVesselsClassB:        calculateVesselCounts()["Class B"],
             NumVesselsAtoN:          calculateVesselCounts()["AtoN"],
@@ -3052,7 +2935,7 @@ go func() {
 	go func() {
 	    ticker := time.NewTicker(time.Duration(*updateInterval) * time.Second)
 	    for range ticker.C {
-	        now := time.Now().UTC()
+	        now := clockNow()
 	        cutoff := now.Add(-*expireAfter)
         
 	        // Step 1: Clean timestamps and calculate counts while holding vesselMsgTimestampsMutex.
@@ -3074,7 +2957,7 @@ go func() {
 	        vesselDataMutex.Lock()
 	        for vesselID, count := range validCounts {
 	            if vessel, exists := vesselData[vesselID]; exists {
-	                vessel["NumMessages"] = float64(count)
+	                vessel.NumMessages = count
 	            }
 	        }
 	        vesselDataMutex.Unlock()
@@ -3082,275 +2965,9 @@ go func() {
 	}()
 
 
-	// --- Read from serial port line-by-line (if -serial-port is specified) ---
-	if *serialPort != "" {
-	    scanner := bufio.NewScanner(port)
-	    for scanner.Scan() {
-		line := scanner.Text()
-		currentTime := time.Now().UTC().Format(time.RFC3339Nano)
-		source := "Serial"
-		if *debug {
-			log.Printf("[DEBUG] Received from Serial (%s) at %s: %s", source, currentTime, line)
-		}
-		if len(line) == 0 || (line[0] != '!' && line[0] != '$') {
-			continue
-		}
-		serialCounter.AddEvent()
-		totalMessages++
-		// Check deduplication for the serial data.
-		if *dedupeWindowDuration > 0 && isDuplicateWithLock(line, &websocketDedupeWindow, &websocketDedupeMutex, windowDuration) {
-			if *debug {
-				log.Printf("[DEBUG] Dropped duplicate serial message (%s) at %s: %s", source, currentTime, line)
-			}
-			continue
-		}
-		appendToWindowWithLock(line, &websocketDedupeWindow, &websocketDedupeMutex)
-		// Also record in the aggregator dedupe window.
-		appendToWindowWithLock(line, &aggregatorDedupeWindow, &aggregatorDedupeMutex)
-
-       		 if len(aggregatorConns) > 0 {
-       		     for _, conn := range aggregatorConns {
-      		          if _, err := conn.Write([]byte(line)); err != nil {
-		                    if *debug {
-		                        log.Printf("[DEBUG] Error sending raw NMEA sentence over UDP to aggregator: %v", err)
-		                    }
-		                } else {
-		                    if *debug {
-		                        log.Printf("[DEBUG] Forwarded raw NMEA sentence over UDP to aggregator: %s", line)
-		                    }
-		                }
-		            }
-		        }
-
-		decoded, err := nmeaCodec.ParseSentence(line)
-		if err != nil {
-			log.Printf("Error decoding sentence: %v", err)
-			continue
-		}
-			if decoded == nil || decoded.Packet == nil {
-				continue
-			}
-
-			if *logAllDecodesDir != "" {
-			    logDecodedMessage(decoded.Packet, *logAllDecodesDir)
-			}
-
-			// Convert the decoded packet to a map so we can clean it.
-			var newData map[string]interface{}
-			{
-			    b, err := json.Marshal(decoded.Packet)
-			    if err != nil {
-			        log.Printf("Error marshaling AIS packet: %v", err)
-			        continue
-			    }
-			    if err := json.Unmarshal(b, &newData); err != nil {
-			        log.Printf("Error unmarshaling AIS packet to map: %v", err)
-			        continue
-			    }
-			}
-
-			// Clean the invalid data.
-			cleanInvalidData(newData)
-			
-			// Build the AIS message with cleaned data.
-			typeName := getMessageTypeName(decoded.Packet)
-			aisMsg := AISMessage{
-			    Type:      typeName,
-			    Data:      newData,
-			    Timestamp: time.Now().UTC().Format(time.RFC3339Nano),
-			}
-			finalMsg, err := json.Marshal(aisMsg)
-			if err != nil {
-			    log.Printf("Error marshaling AISMessage: %v", err)
-			    continue
-			}
-			if *showDecodes {
-			    log.Println("Decoded AIS Packet:", string(finalMsg))
-			}
-		
-			// Use the same 	newData to extract the UserID.
-				userIDFloat, ok := newData["UserID"].(float64)
-			if !ok {
-			    availableKeys := make([]string, 0, len(newData))
-			    for key := range newData {
-			        availableKeys = append(availableKeys, key)
-			    }
-			    log.Printf("Vessel packet missing or invalid UserID field. Available keys: %v", availableKeys)
-			    continue
-			}
-			vesselID := fmt.Sprintf("%.0f", userIDFloat)
-			var MID int
-			if len(vesselID) >= 3 {
-			    MID, _ = strconv.Atoi(vesselID[:3])
-			} else {
-			    MID, _ = strconv.Atoi(vesselID)
-			}
-			newData["MID"] = MID
-	
-			roomName := "ais_data/" + vesselID
-			if err := sioServer.To(socket.Room(roomName)).Emit("ais_data", string(finalMsg)); err != nil {
-			    log.Printf("Error sending decoded AIS data to room %s: %v", roomName, err)
-			}
-
-			// Update vessel state using the same newData.
-			vesselDataMutex.Lock()
-			msgType := getMessageTypeName(decoded.Packet)
-			merged := mergeMaps(vesselData[vesselID], newData, msgType)
-			merged["LastUpdated"] = time.Now().UTC().Format(time.RFC3339Nano)
-			addMessageType(merged, decoded.Packet)
-			// Get current time
-			now := time.Now().UTC()
-
-			// Lock the timestamp map for the current vessel.
-			vesselMsgTimestampsMutex.Lock()
-			vesselMsgTimestamps[vesselID] = append(vesselMsgTimestamps[vesselID], now)
-
-			// Remove timestamps older than expire-after.
-			cutoff := now.Add(-*expireAfter)
-			validTimestamps := vesselMsgTimestamps[vesselID][:0]
-			for _, t := range vesselMsgTimestamps[vesselID] {
-			    if t.After(cutoff) {
-			        validTimestamps = append(validTimestamps, t)
-			    }
-			}
-			vesselMsgTimestamps[vesselID] = validTimestamps
-			vesselMsgTimestampsMutex.Unlock()
-
-			// Set rolling total for NumMessages.
-			merged["NumMessages"] = float64(len(validTimestamps))
-
-			vesselData[vesselID] = merged
-			vesselDataMutex.Unlock()
-
-			if *externalLookupURL != "" {
-			    vesselDataMutex.Lock()
-			    _, exists := vesselData[vesselID]
-			    vesselDataMutex.Unlock()
-			
-			    if !exists {
-			        go externalLookupCall(vesselID, *externalLookupURL, *stateDir)
-			    } else {
-			        name, ok := merged["Name"].(string)
-			        if !ok || strings.TrimSpace(name) == "" || name == "NO NAME" {
-			            go externalLookupCall(vesselID, *externalLookupURL, *stateDir)
-			        }
-			    }
-			}
-
-			// Append to vessel history only if lat/lon have changed by an acceptable amount.
-			if lat, ok := merged["Latitude"].(float64); ok {
-    			if lon, ok := merged["Longitude"].(float64); ok {
-			        vesselHistoryMutex.Lock()
-			        last, exists := vesselLastCoordinates[vesselID]
-			        distance := 0.0
-			        if exists {
-			            distance = haversine(last.lat, last.lon, lat, lon)
-			        }
-			
-			        // Check for spurious jump: if the distance is greater than 10 km.
-			        if exists && distance > 10000.0 {
-			            pendingVesselDataMutex.Lock()
-			            if pending, found := pendingVesselData[vesselID]; found {
-			                // Compare new reading to the already pending one.
-			                pLat, ok1 := pending["Latitude"].(float64)
-			                pLon, ok2 := pending["Longitude"].(float64)
-			                if ok1 && ok2 {
-			                    pendingDistance := haversine(pLat, pLon, lat, lon)
-			                    if pendingDistance <= 10000.0 {
-			                        // The new reading is close enough to the pending update.
-			                        // Commit the pending update to the vessel's current state.
-			                        vesselDataMutex.Lock()
-			                        vesselData[vesselID] = pending
-			                        vesselDataMutex.Unlock()
-			                        // Update the baseline coordinate.
-			                        vesselLastCoordinates[vesselID] = struct{ lat, lon float64 }{pLat, pLon}
-			
-			                        // Append the pending update to history.
-			                        ts := pending["LastUpdated"].(string)
-			                        var sogStr, cogStr, trueHeadingStr string
-			                        if sog, ok := pending["Sog"].(float64); ok {
-			                            sogStr = fmt.Sprintf("%.2f", sog)
-			                        }
-			                        if cog, ok := pending["Cog"].(float64); ok {
-			                            cogStr = fmt.Sprintf("%.2f", cog)
-			                        }
-			                        if th, ok := pending["TrueHeading"].(float64); ok {
-			                            trueHeadingStr = fmt.Sprintf("%.2f", th)
-			                        }
-			                        if !*noState {
-			                            if err := appendHistory(historyBase, vesselID, pLat, pLon, sogStr, cogStr, trueHeadingStr, ts); err != nil {
-			                                log.Printf("Error appending history for vessel %s: %v", vesselID, err)
-			                            }
-			                        }
-			                        // Remove the pending update.
-			                        delete(pendingVesselData, vesselID)
-			                    } else {
-			                        // The new update is still far from the pending one; update the pending update.
-			                        pendingVesselData[vesselID] = merged
-			                    }
-			                }
-			            } else {
-			                // No pending update exists yet—store this spurious reading.
-			                pendingVesselData[vesselID] = merged
-			            }
-			            pendingVesselDataMutex.Unlock()
-			            vesselHistoryMutex.Unlock()
-			            // Do not update the current state with this spurious reading.
-			            continue			
-			        }
-
-			        // For very small movements (<10 m), keep the current behavior.
-			        if exists && distance < 10.0 {
-			            vesselLastCoordinates[vesselID] = struct{ lat, lon float64 }{lat, lon}
-				    if receiverLat, receiverLon, err := loadReceiverCoordinates(*stateDir); err == nil {
-     					updateDistanceMetrics(lat, lon, receiverLat, receiverLon)
-				    } 
-			            vesselHistoryMutex.Unlock()
-			            continue
-			        }
-			
-			        // Otherwise, the update is within acceptable bounds.
-			        // Commit the update normally.
-			        ts := merged["LastUpdated"].(string)
-			        var sogStr, cogStr, trueHeadingStr string
-			        if sog, ok := merged["Sog"].(float64); ok {
-			            sogStr = fmt.Sprintf("%.2f", sog)
-			        }
-			        if cog, ok := merged["Cog"].(float64); ok {
-			            cogStr = fmt.Sprintf("%.2f", cog)
-			        }
-			        if th, ok := merged["TrueHeading"].(float64); ok {
-			            trueHeadingStr = fmt.Sprintf("%.2f", th)
-			        }
-			
-			        // Append the valid update to history.
-			        if !*noState {
-			            if err := appendHistory(historyBase, vesselID, lat, lon, sogStr, cogStr, trueHeadingStr, ts); err != nil {
-			                log.Printf("Error appending history for vessel %s: %v", vesselID, err)
-			            }
-			        }
-			        // Update the baseline coordinate for future comparisons.
-			        vesselLastCoordinates[vesselID] = struct{ lat, lon float64 }{lat, lon}
-				if receiverLat, receiverLon, err := loadReceiverCoordinates(*stateDir); err == nil {
-    				   updateDistanceMetrics(lat, lon, receiverLat, receiverLon)
-				} 
-			        vesselHistoryMutex.Unlock()
-			    }
-			}
-
-			vesselDataMutex.Lock()
-			latestData := filterCompleteVesselData(vesselData)
-			vesselDataMutex.Unlock()
-			if !isDataChanged(latestData, previousVesselData) {
-				continue
-			}
-			changeMutex.Lock()
-			changeAvailable = true
-			changeMutex.Unlock()
-		}
-		if err := scanner.Err(); err != nil {
-			log.Printf("Error reading from serial port: %v", err)
-		}
+	// --- Read from every serial port, one supervised goroutine per port ---
+	for _, cfg := range serialConfigs {
+		go pipeline.Run(&serialSource{cfg: cfg, debug: *debug})
 	}
 
 	// Wait forever.
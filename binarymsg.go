@@ -0,0 +1,370 @@
+package main
+
+// Application identifiers (DAC, FI) of the binary messages decoded from
+// types 6 and 8. Anything else stays opaque.
+const (
+	dacInternational = 1   // IMO SN.1/Circ.289
+	dacInland        = 200 // European inland waterways (CCNR)
+
+	fiAreaNotice         = 22
+	fiExtendedShipStatic = 24
+	fiMeteoHydro         = 31
+	fiInlandShipStatic   = 10
+)
+
+// BinaryApplication is a decoded type 6 (addressed) or type 8 (broadcast)
+// binary message. Exactly one of the content fields is set.
+type BinaryApplication struct {
+	DAC           uint16
+	FI            uint8
+	DestinationID uint32 `json:",omitempty"` // type 6 only
+
+	MeteoHydro     *MeteoHydro         `json:",omitempty"`
+	AreaNotice     *AreaNotice         `json:",omitempty"`
+	ExtendedStatic *ExtendedShipStatic `json:",omitempty"`
+	InlandStatic   *InlandShipStatic   `json:",omitempty"`
+}
+
+// MeteoHydro is DAC 1 FI 31, meteorological and hydrographic data. Values
+// the station marks as not available are nil. Speeds are in knots,
+// directions in degrees, temperatures in °C, heights in metres.
+type MeteoHydro struct {
+	Latitude            *float64 `json:",omitempty"`
+	Longitude           *float64 `json:",omitempty"`
+	PositionAccuracy    bool
+	Day                 uint8    `json:",omitempty"` // UTC day of month of the observation, 0 if unknown
+	Hour                *uint8   `json:",omitempty"`
+	Minute              *uint8   `json:",omitempty"`
+	WindSpeed           *float64 `json:",omitempty"`
+	WindGust            *float64 `json:",omitempty"`
+	WindDirection       *float64 `json:",omitempty"`
+	WindGustDirection   *float64 `json:",omitempty"`
+	AirTemperature      *float64 `json:",omitempty"`
+	RelativeHumidity    *float64 `json:",omitempty"` // percent
+	DewPoint            *float64 `json:",omitempty"`
+	AirPressure         *float64 `json:",omitempty"` // hPa
+	AirPressureTendency *uint8   `json:",omitempty"` // 0 steady, 1 decreasing, 2 increasing
+	Visibility          *float64 `json:",omitempty"` // nautical miles
+	VisibilityAbove     bool     `json:",omitempty"` // visibility is greater than the value given
+	WaterLevel          *float64 `json:",omitempty"` // relative to local chart datum
+	WaterLevelTrend     *uint8   `json:",omitempty"` // 0 steady, 1 decreasing, 2 increasing
+	CurrentSpeed        *float64 `json:",omitempty"` // surface current
+	CurrentDirection    *float64 `json:",omitempty"`
+	WaveHeight          *float64 `json:",omitempty"` // significant wave height
+	WavePeriod          *float64 `json:",omitempty"` // seconds
+	WaveDirection       *float64 `json:",omitempty"`
+	SwellHeight         *float64 `json:",omitempty"`
+	SwellPeriod         *float64 `json:",omitempty"`
+	SwellDirection      *float64 `json:",omitempty"`
+	SeaState            *uint8   `json:",omitempty"` // Beaufort
+	WaterTemperature    *float64 `json:",omitempty"`
+	Precipitation       *uint8   `json:",omitempty"` // WMO 306 code table 4.201
+	Salinity            *float64 `json:",omitempty"` // ‰
+	Ice                 *uint8   `json:",omitempty"` // 0 no, 1 yes
+}
+
+// AreaNotice is DAC 1 FI 22: a notice (code from IMO SN.1/Circ.289 table
+// 11.10) valid for Duration minutes from the given UTC time over the union of
+// its sub-areas.
+type AreaNotice struct {
+	LinkageID  uint16
+	NoticeType uint8
+	Month      uint8
+	Day        uint8
+	Hour       uint8
+	Minute     uint8
+	Duration   *uint32 `json:",omitempty"` // minutes; nil means until cancelled
+	SubAreas   []AreaNoticeSubArea
+}
+
+// AreaNoticeSubArea is one shape of an area notice. Distances are in
+// metres, angles in degrees. Polyline and polygon points are relative to
+// the position of the preceding sub-area.
+type AreaNoticeSubArea struct {
+	Shape          string            // circle, rectangle, sector, polyline, polygon or text
+	Latitude       *float64          `json:",omitempty"`
+	Longitude      *float64          `json:",omitempty"`
+	Radius         *float64          `json:",omitempty"` // circle (0 is a point) and sector
+	EastDimension  *float64          `json:",omitempty"`
+	NorthDimension *float64          `json:",omitempty"`
+	Orientation    *uint16           `json:",omitempty"`
+	LeftBound      *uint16           `json:",omitempty"`
+	RightBound     *uint16           `json:",omitempty"`
+	Points         []AreaNoticePoint `json:",omitempty"`
+	Text           string            `json:",omitempty"`
+}
+
+type AreaNoticePoint struct {
+	Angle    float64
+	Distance float64
+}
+
+// ExtendedShipStatic is DAC 1 FI 24, extended ship static and voyage data.
+type ExtendedShipStatic struct {
+	AirDraught       *float64 `json:",omitempty"` // metres
+	LastPortOfCall   string   `json:",omitempty"` // UN/LOCODE
+	NextPortOfCall   string   `json:",omitempty"`
+	SecondPortOfCall string   `json:",omitempty"`
+	IceClass         uint8
+	ShaftPower       *uint32 `json:",omitempty"` // horsepower
+	VHFChannel       *uint16 `json:",omitempty"`
+	LloydsShipType   string  `json:",omitempty"`
+	GrossTonnage     *uint32 `json:",omitempty"`
+	LadenOrBallast   uint8   // 1 laden, 2 ballast, 0 unknown
+	BunkerOil        *uint16 `json:",omitempty"` // tonnes
+	PersonsOnBoard   *uint16 `json:",omitempty"`
+}
+
+// InlandShipStatic is DAC 200 FI 10, inland ship static and voyage data.
+type InlandShipStatic struct {
+	ENI            string   `json:",omitempty"` // European vessel identification number
+	Length         *float64 `json:",omitempty"` // metres
+	Beam           *float64 `json:",omitempty"`
+	ShipType       uint16   // ERI ship or combination type
+	HazardousCargo *uint8   `json:",omitempty"` // number of blue cones, or 4 for a B-flag
+	Draught        *float64 `json:",omitempty"`
+	Loaded         *bool    `json:",omitempty"`
+	SpeedQuality   bool     // true if speed comes from a certified sensor
+	CourseQuality  bool
+	HeadingQuality bool
+}
+
+// decodeBinaryApplication decodes the application data of a type 6 or 8
+// sentence. It returns nil for other messages, for application IDs it does
+// not know, and for payloads too short for their layout.
+func decodeBinaryApplication(sentence string) *BinaryApplication {
+	bits, ok := newPayloadBits(sentence)
+	if !ok {
+		return nil
+	}
+	app := &BinaryApplication{}
+	switch bits.uint(6) {
+	case 6:
+		bits.skip(2 + 30 + 2) // repeat, MMSI, sequence number
+		app.DestinationID = uint32(bits.uint(30))
+		bits.skip(2) // retransmit flag, spare
+	case 8:
+		bits.skip(2 + 30 + 2) // repeat, MMSI, spare
+	default:
+		return nil
+	}
+	app.DAC = uint16(bits.uint(10))
+	app.FI = uint8(bits.uint(6))
+
+	switch {
+	case app.DAC == dacInternational && app.FI == fiMeteoHydro:
+		app.MeteoHydro = decodeMeteoHydro(bits)
+	case app.DAC == dacInternational && app.FI == fiAreaNotice:
+		app.AreaNotice = decodeAreaNotice(bits)
+	case app.DAC == dacInternational && app.FI == fiExtendedShipStatic:
+		app.ExtendedStatic = decodeExtendedShipStatic(bits)
+	case app.DAC == dacInland && app.FI == fiInlandShipStatic:
+		app.InlandStatic = decodeInlandShipStatic(bits)
+	default:
+		return nil
+	}
+	if bits.short {
+		return nil
+	}
+	return app
+}
+
+func decodeMeteoHydro(bits *payloadBits) *MeteoHydro {
+	m := &MeteoHydro{}
+	m.Longitude = binaryCoordinate(bits.int(25), 180)
+	m.Latitude = binaryCoordinate(bits.int(24), 90)
+	m.PositionAccuracy = bits.bool()
+	m.Day = uint8(bits.uint(5))
+	m.Hour = binaryCode(bits.uint(5), 23)
+	m.Minute = binaryCode(bits.uint(6), 59)
+	m.WindSpeed = binaryValue(bits.uint(7), 127, 1, 0)
+	m.WindGust = binaryValue(bits.uint(7), 127, 1, 0)
+	m.WindDirection = binaryValue(bits.uint(9), 360, 1, 0)
+	m.WindGustDirection = binaryValue(bits.uint(9), 360, 1, 0)
+	if t := bits.int(11); t != -1024 {
+		m.AirTemperature = binaryFloat(float64(t) / 10)
+	}
+	m.RelativeHumidity = binaryValue(bits.uint(7), 101, 1, 0)
+	if t := bits.int(10); t != 501 {
+		m.DewPoint = binaryFloat(float64(t) / 10)
+	}
+	// 0 means 799 hPa or less, 402 means 1201 hPa or more.
+	m.AirPressure = binaryValue(bits.uint(9), 403, 1, 799)
+	m.AirPressureTendency = binaryCode(bits.uint(2), 2)
+	m.VisibilityAbove = bits.bool()
+	m.Visibility = binaryValue(bits.uint(7), 127, 10, 0)
+	m.WaterLevel = binaryValue(bits.uint(12), 4001, 100, -10)
+	m.WaterLevelTrend = binaryCode(bits.uint(2), 2)
+	m.CurrentSpeed = binaryValue(bits.uint(8), 251, 10, 0)
+	m.CurrentDirection = binaryValue(bits.uint(9), 360, 1, 0)
+	bits.skip(2 * (8 + 9 + 5)) // currents at two further depths
+	m.WaveHeight = binaryValue(bits.uint(8), 251, 10, 0)
+	m.WavePeriod = binaryValue(bits.uint(6), 61, 1, 0)
+	m.WaveDirection = binaryValue(bits.uint(9), 360, 1, 0)
+	m.SwellHeight = binaryValue(bits.uint(8), 251, 10, 0)
+	m.SwellPeriod = binaryValue(bits.uint(6), 61, 1, 0)
+	m.SwellDirection = binaryValue(bits.uint(9), 360, 1, 0)
+	m.SeaState = binaryCode(bits.uint(4), 12)
+	if t := bits.int(10); t != 501 {
+		m.WaterTemperature = binaryFloat(float64(t) / 10)
+	}
+	m.Precipitation = binaryCode(bits.uint(3), 6)
+	m.Salinity = binaryValue(bits.uint(9), 501, 10, 0)
+	m.Ice = binaryCode(bits.uint(2), 1)
+	return m
+}
+
+// areaNoticeShapes names the sub-area shape codes.
+var areaNoticeShapes = []string{"circle", "rectangle", "sector", "polyline", "polygon", "text"}
+
+func decodeAreaNotice(bits *payloadBits) *AreaNotice {
+	n := &AreaNotice{
+		LinkageID:  uint16(bits.uint(10)),
+		NoticeType: uint8(bits.uint(7)),
+		Month:      uint8(bits.uint(4)),
+		Day:        uint8(bits.uint(5)),
+		Hour:       uint8(bits.uint(5)),
+		Minute:     uint8(bits.uint(6)),
+	}
+	if d := bits.uint(18); d != 1<<18-1 {
+		duration := uint32(d)
+		n.Duration = &duration
+	}
+	// Sub-areas are 87 bits each; the message fills up to five slots.
+	for bits.left() >= 87 {
+		shape := bits.uint(3)
+		if int(shape) >= len(areaNoticeShapes) {
+			break
+		}
+		area := AreaNoticeSubArea{Shape: areaNoticeShapes[shape]}
+		if shape == 5 {
+			area.Text = bits.text(14)
+			n.SubAreas = append(n.SubAreas, area)
+			continue
+		}
+		scale := float64([]int{1, 10, 100, 1000}[bits.uint(2)])
+		if shape == 3 || shape == 4 {
+			for i := 0; i < 4; i++ {
+				angle, distance := bits.uint(10), bits.uint(10)
+				if angle != 720 {
+					area.Points = append(area.Points, AreaNoticePoint{
+						Angle:    float64(angle) / 2,
+						Distance: float64(distance) * scale,
+					})
+				}
+			}
+			bits.skip(2)
+			n.SubAreas = append(n.SubAreas, area)
+			continue
+		}
+		area.Longitude = binaryCoordinate(bits.int(25), 180)
+		area.Latitude = binaryCoordinate(bits.int(24), 90)
+		bits.skip(3) // precision
+		switch shape {
+		case 0:
+			area.Radius = binaryFloat(float64(bits.uint(12)) * scale)
+			bits.skip(18)
+		case 1:
+			area.EastDimension = binaryFloat(float64(bits.uint(8)) * scale)
+			area.NorthDimension = binaryFloat(float64(bits.uint(8)) * scale)
+			orientation := uint16(bits.uint(9))
+			area.Orientation = &orientation
+			bits.skip(5)
+		case 2:
+			area.Radius = binaryFloat(float64(bits.uint(12)) * scale)
+			left, right := uint16(bits.uint(9)), uint16(bits.uint(9))
+			area.LeftBound, area.RightBound = &left, &right
+		}
+		n.SubAreas = append(n.SubAreas, area)
+	}
+	return n
+}
+
+func decodeExtendedShipStatic(bits *payloadBits) *ExtendedShipStatic {
+	e := &ExtendedShipStatic{}
+	bits.skip(10) // linkage ID
+	e.AirDraught = binaryValue(bits.uint(13), 0, 10, 0)
+	e.LastPortOfCall = bits.text(5)
+	e.NextPortOfCall = bits.text(5)
+	e.SecondPortOfCall = bits.text(5)
+	bits.skip(26) // SOLAS equipment status
+	e.IceClass = uint8(bits.uint(4))
+	if hp := uint32(bits.uint(18)); hp != 0 && hp != 1<<18-1 {
+		e.ShaftPower = &hp
+	}
+	if ch := uint16(bits.uint(12)); ch != 0 {
+		e.VHFChannel = &ch
+	}
+	e.LloydsShipType = bits.text(7)
+	if gt := uint32(bits.uint(18)); gt != 0 && gt != 1<<18-1 {
+		e.GrossTonnage = &gt
+	}
+	e.LadenOrBallast = uint8(bits.uint(2))
+	bits.skip(3 * 2) // heavy fuel, light fuel and diesel bunker flags
+	if oil := uint16(bits.uint(14)); oil != 1<<14-1 {
+		e.BunkerOil = &oil
+	}
+	if pob := uint16(bits.uint(13)); pob != 0 && pob != 8191 {
+		e.PersonsOnBoard = &pob
+	}
+	return e
+}
+
+func decodeInlandShipStatic(bits *payloadBits) *InlandShipStatic {
+	s := &InlandShipStatic{}
+	s.ENI = bits.text(8)
+	s.Length = binaryValue(bits.uint(13), 0, 10, 0)
+	s.Beam = binaryValue(bits.uint(10), 0, 10, 0)
+	s.ShipType = uint16(bits.uint(14))
+	s.HazardousCargo = binaryCode(bits.uint(3), 4)
+	s.Draught = binaryValue(bits.uint(11), 0, 100, 0)
+	switch bits.uint(2) {
+	case 1:
+		loaded := true
+		s.Loaded = &loaded
+	case 2:
+		loaded := false
+		s.Loaded = &loaded
+	}
+	s.SpeedQuality = bits.bool()
+	s.CourseQuality = bits.bool()
+	s.HeadingQuality = bits.bool()
+	return s
+}
+
+// binaryValue returns raw/divisor + offset, or nil if raw is the field's
+// not-available value (or beyond it). A notAvailable of 0 means 0 is the
+// not-available value.
+func binaryValue(raw, notAvailable uint64, divisor, offset float64) *float64 {
+	if notAvailable == 0 {
+		if raw == 0 {
+			return nil
+		}
+	} else if raw >= notAvailable {
+		return nil
+	}
+	return binaryFloat((float64(raw) + offset*divisor) / divisor)
+}
+
+// binaryCode returns a code field, or nil if it is above max.
+func binaryCode(raw, max uint64) *uint8 {
+	if raw > max {
+		return nil
+	}
+	v := uint8(raw)
+	return &v
+}
+
+// binaryCoordinate converts 1/1000 minutes to degrees, or nil if out of
+// range (181° and 91° mean not available).
+func binaryCoordinate(raw int64, limit float64) *float64 {
+	deg := float64(raw) / 60000
+	if deg < -limit || deg > limit {
+		return nil
+	}
+	return &deg
+}
+
+func binaryFloat(v float64) *float64 {
+	return &v
+}
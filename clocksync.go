@@ -0,0 +1,246 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	ais "github.com/BertoldVdb/go-ais"
+)
+
+// clockWindow is the period ClockStats are taken over. Base stations report
+// every 10 seconds, so this is about 60 reports per station heard.
+const clockWindow = 10 * time.Minute
+
+// ClockStats compares the UTC broadcast in type 4 base station reports with
+// our time stamps, over the last clockWindow.
+//
+// Offset is the receive time stamp (tag block c: time if the receiver sent
+// one, else our arrival time) minus the reported UTC, so it is the error of
+// whichever clock stamped the sentence. Latency is our arrival time minus the
+// reported UTC, and includes any forwarding delay. The reported UTC has whole
+// second resolution. Sub-second stamps are compared with the middle of that
+// second; tag block c: stamps are whole seconds too and are compared with its
+// start. Either way they are good to about half a second, and medians are
+// used so a stale relay does not skew them.
+type ClockStats struct {
+	Reports         int     `json:"reports"`
+	MedianOffsetMs  float64 `json:"median_offset_ms"`
+	MedianLatencyMs float64 `json:"median_latency_ms"`
+}
+
+type clockSample struct {
+	at      time.Time
+	offset  time.Duration
+	latency time.Duration
+}
+
+type clockSamples struct {
+	samples []clockSample
+}
+
+var (
+	clockMutex sync.Mutex
+	// clockByBaseStation is keyed by base station MMSI, clockByReceiver by
+	// tag block station, else input source.
+	clockByBaseStation = make(map[string]*clockSamples)
+	clockByReceiver    = make(map[string]*clockSamples)
+)
+
+func (cs *clockSamples) pruneLocked(now time.Time) {
+	cutoff := now.Add(-clockWindow)
+	i := 0
+	for i < len(cs.samples) && cs.samples[i].at.Before(cutoff) {
+		i++
+	}
+	cs.samples = cs.samples[i:]
+}
+
+func (cs *clockSamples) stats() ClockStats {
+	offsets := make([]time.Duration, len(cs.samples))
+	latencies := make([]time.Duration, len(cs.samples))
+	for i, s := range cs.samples {
+		offsets[i], latencies[i] = s.offset, s.latency
+	}
+	return ClockStats{
+		Reports:         len(cs.samples),
+		MedianOffsetMs:  medianMilliseconds(offsets),
+		MedianLatencyMs: medianMilliseconds(latencies),
+	}
+}
+
+func medianMilliseconds(values []time.Duration) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+	mid := len(values) / 2
+	median := values[mid]
+	if len(values)%2 == 0 {
+		median = (values[mid-1] + values[mid]) / 2
+	}
+	return float64(median.Round(time.Millisecond)) / float64(time.Millisecond)
+}
+
+// baseStationTime returns the UTC a base station report carries, or false if
+// the station has no time (year 0, hour 24, minute or second 60 mean not
+// available).
+func baseStationTime(p *ais.BaseStationReport) (time.Time, bool) {
+	if p.UtcYear == 0 || p.UtcMonth == 0 || p.UtcMonth > 12 || p.UtcDay == 0 ||
+		p.UtcHour > 23 || p.UtcMinute > 59 || p.UtcSecond > 59 {
+		return time.Time{}, false
+	}
+	t := time.Date(int(p.UtcYear), time.Month(p.UtcMonth), int(p.UtcDay),
+		int(p.UtcHour), int(p.UtcMinute), int(p.UtcSecond), 0, time.UTC)
+	// time.Date normalises 31 February etc. into the next month.
+	if t.Day() != int(p.UtcDay) {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// recordBaseStationClock adds a sample for the base station and for the
+// receiver that heard it.
+func recordBaseStationClock(msg *DecodedMessage) {
+	p, ok := msg.Packet.(*ais.BaseStationReport)
+	if !ok || p.RepeatIndicator != 0 {
+		// Repeated reports were delayed by the repeater.
+		return
+	}
+	utc, ok := baseStationTime(p)
+	if !ok {
+		return
+	}
+	// The report was sent some time during the second it gives, so compare
+	// sub-second stamps with the middle of it. Tag block c: stamps are whole
+	// seconds, rounded down like the reported UTC, so they compare directly.
+	mid := utc.Add(500 * time.Millisecond)
+	received := mid
+	if msg.ReceivedAt.Nanosecond() == 0 {
+		received = utc
+	}
+	sample := clockSample{
+		at:      msg.ArrivedAt,
+		offset:  msg.ReceivedAt.Sub(received),
+		latency: msg.ArrivedAt.Sub(mid),
+	}
+	receiver := msg.Station
+	if receiver == "" {
+		receiver = msg.Source
+	}
+
+	clockMutex.Lock()
+	defer clockMutex.Unlock()
+	for _, entry := range []struct {
+		stats map[string]*clockSamples
+		key   string
+	}{
+		{clockByBaseStation, strconv.FormatUint(uint64(p.UserID), 10)},
+		{clockByReceiver, receiver},
+	} {
+		cs, ok := entry.stats[entry.key]
+		if !ok {
+			cs = &clockSamples{}
+			entry.stats[entry.key] = cs
+		}
+		cs.samples = append(cs.samples, sample)
+		cs.pruneLocked(sample.at)
+	}
+}
+
+// clockMetrics snapshots ClockStats per base station and per receiver.
+func clockMetrics() (byBaseStation, byReceiver map[string]ClockStats) {
+	clockMutex.Lock()
+	defer clockMutex.Unlock()
+	now := clockNow()
+	snapshot := func(all map[string]*clockSamples) map[string]ClockStats {
+		result := make(map[string]ClockStats, len(all))
+		for key, cs := range all {
+			cs.pruneLocked(now)
+			if len(cs.samples) == 0 {
+				delete(all, key)
+				continue
+			}
+			result[key] = cs.stats()
+		}
+		if len(result) == 0 {
+			return nil
+		}
+		return result
+	}
+	return snapshot(clockByBaseStation), snapshot(clockByReceiver)
+}
+
+// clockSink feeds base station reports into the clock statistics.
+type clockSink struct{}
+
+func (s *clockSink) RawSentence(msg *Sentence) {}
+
+func (s *clockSink) Decoded(msg *DecodedMessage) {
+	recordBaseStationClock(msg)
+}
+
+// flagReceiverClocks records the measured clock offset of every receiver in
+// receivers.json that sends tag blocks, and marks it clock_out_of_sync if
+// the offset is more than threshold either way.
+func flagReceiverClocks(stateDir string, threshold time.Duration) error {
+	_, byReceiver := clockMetrics()
+	if len(byReceiver) == 0 {
+		return nil
+	}
+	receiversMutex.Lock()
+	defer receiversMutex.Unlock()
+	receiversPath := filepath.Join(stateDir, "receivers.json")
+	receivers, err := loadReceivers(receiversPath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	changed := false
+	for key, rec := range receivers {
+		// Receivers stamp their sentences with the station ID from their
+		// myinfo.json: the name if set, else the UUID.
+		stats, ok := byReceiver[sanitizeTagValue(rec["name"])]
+		if !ok {
+			stats, ok = byReceiver[strings.TrimSpace(rec["uuid"])]
+		}
+		if !ok {
+			continue
+		}
+		offset := strconv.FormatFloat(stats.MedianOffsetMs, 'f', 0, 64)
+		outOfSync := strconv.FormatBool(time.Duration(stats.MedianOffsetMs*float64(time.Millisecond)).Abs() > threshold)
+		if rec["clock_offset_ms"] == offset && rec["clock_out_of_sync"] == outOfSync {
+			continue
+		}
+		if outOfSync == "true" && rec["clock_out_of_sync"] != "true" {
+			log.Printf("Receiver %s clock is out of sync by %s ms", rec["uuid"], offset)
+		}
+		rec["clock_offset_ms"] = offset
+		rec["clock_out_of_sync"] = outOfSync
+		receivers[key] = rec
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	return saveReceivers(receiversPath, receivers)
+}
+
+// scheduleReceiverClockCheck runs flagReceiverClocks once a minute.
+func scheduleReceiverClockCheck(stateDir string, threshold time.Duration) {
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := flagReceiverClocks(stateDir, threshold); err != nil {
+				log.Printf("Error flagging receiver clocks: %v", err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"hash/fnv"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Values for -dedupe-mode.
+const (
+	dedupeModeSentence = "sentence" // whole bare sentence must match
+	dedupeModePayload  = "payload"  // only the decoded message bits must match
+)
+
+// dedupeBucketsPerWindow sets the expiry granularity: an entry is forgotten
+// between window and window*(1+1/dedupeBucketsPerWindow) after it was seen.
+const dedupeBucketsPerWindow = 8
+
+// dedupeBucket holds the keys first seen during one slice of the window.
+type dedupeBucket struct {
+	start time.Time
+	keys  []uint64
+}
+
+// DedupeSourceStats counts, for one source, how many of its messages were
+// the first copy seen and how many duplicated one already seen elsewhere.
+type DedupeSourceStats struct {
+	First     int `json:"first"`
+	Duplicate int `json:"duplicate"`
+}
+
+// DedupeSet remembers recently seen messages by hash. Lookups are O(1);
+// expiry drops whole time buckets instead of rescanning every entry.
+type DedupeSet struct {
+	mu          sync.Mutex
+	window      time.Duration
+	bucketWidth time.Duration
+	seen        map[uint64]time.Time // message hash -> first seen
+	buckets     []dedupeBucket       // oldest first
+	stats       map[string]*DedupeSourceStats
+}
+
+var dedupeSet = newDedupeSet(time.Second)
+
+func newDedupeSet(window time.Duration) *DedupeSet {
+	ds := &DedupeSet{
+		seen:  make(map[uint64]time.Time),
+		stats: make(map[string]*DedupeSourceStats),
+	}
+	ds.setWindowLocked(window)
+	return ds
+}
+
+// SetWindow changes how long a message is remembered.
+func (ds *DedupeSet) SetWindow(window time.Duration) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	ds.setWindowLocked(window)
+}
+
+func (ds *DedupeSet) setWindowLocked(window time.Duration) {
+	ds.window = window
+	ds.bucketWidth = window / dedupeBucketsPerWindow
+	if ds.bucketWidth <= 0 {
+		ds.bucketWidth = time.Millisecond
+	}
+}
+
+// Check reports whether message was already seen within the window and
+// records it if not. source is credited with a first or a duplicate.
+func (ds *DedupeSet) Check(message, source string) bool {
+	h := fnv.New64a()
+	h.Write([]byte(message))
+	key := h.Sum64()
+	now := time.Now()
+
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	ds.expireLocked(now)
+
+	stats, ok := ds.stats[source]
+	if !ok {
+		stats = &DedupeSourceStats{}
+		ds.stats[source] = stats
+	}
+	if first, ok := ds.seen[key]; ok && now.Sub(first) < ds.window {
+		stats.Duplicate++
+		return true
+	}
+	stats.First++
+	ds.seen[key] = now
+
+	if n := len(ds.buckets); n == 0 || now.Sub(ds.buckets[n-1].start) >= ds.bucketWidth {
+		ds.buckets = append(ds.buckets, dedupeBucket{start: now})
+	}
+	last := &ds.buckets[len(ds.buckets)-1]
+	last.keys = append(last.keys, key)
+	return false
+}
+
+// expireLocked drops buckets that ended more than a window ago.
+func (ds *DedupeSet) expireLocked(now time.Time) {
+	i := 0
+	for i < len(ds.buckets) && now.Sub(ds.buckets[i].start) >= ds.window+ds.bucketWidth {
+		for _, key := range ds.buckets[i].keys {
+			// A key seen again after expiry lives on in a newer bucket.
+			if first, ok := ds.seen[key]; ok && !first.After(ds.buckets[i].start.Add(ds.bucketWidth)) {
+				delete(ds.seen, key)
+			}
+		}
+		i++
+	}
+	if i > 0 {
+		ds.buckets = append(ds.buckets[:0], ds.buckets[i:]...)
+	}
+}
+
+// Stats returns the number of remembered messages and a copy of the
+// per-source first/duplicate counts.
+func (ds *DedupeSet) Stats() (size int, bySource map[string]DedupeSourceStats) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	ds.expireLocked(time.Now())
+	bySource = make(map[string]DedupeSourceStats, len(ds.stats))
+	for source, stats := range ds.stats {
+		bySource[source] = *stats
+	}
+	return len(ds.seen), bySource
+}
+
+// payloadDedupeKey reduces an assembled AIS sentence to its message bits, so
+// copies heard on another channel, with another sequence ID or talker, or
+// with different fill bit values key the same. The bits include the type and
+// MMSI, so distinct messages never share a key. It reports false for
+// sentences that are not AIS messages.
+func payloadDedupeKey(sentence string) (string, bool) {
+	bits, ok := newPayloadBits(sentence)
+	if !ok {
+		return "", false
+	}
+	// packed drops the fill bits; their values are arbitrary.
+	return strconv.Itoa(bits.n) + ":" + string(bits.packed()), true
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fragmentKey identifies one multipart message in flight. Sequential message
+// IDs are only unique per transmitter link, so the source address and radio
+// channel are part of the key.
+type fragmentKey struct {
+	source  string
+	channel string
+	seqID   string
+}
+
+// fragmentGroup holds the parts of a multipart message received so far.
+type fragmentGroup struct {
+	formatter string // e.g. "!AIVDM"
+	total     int
+	parts     []string // original sentences, in order
+	payload   strings.Builder
+	fillBits  string
+	started   time.Time
+}
+
+// FragmentAssembler reassembles multipart AIVDM/AIVDO sentences separately
+// for every source and channel, so fragments from different receivers that
+// share a sequential message ID cannot corrupt each other.
+type FragmentAssembler struct {
+	mu         sync.Mutex
+	timeout    time.Duration
+	pending    map[fragmentKey]*fragmentGroup
+	incomplete int // groups dropped before all parts arrived
+	orphaned   int // parts that did not belong to any group in progress
+}
+
+var fragmentAssembler = newFragmentAssembler(5 * time.Second)
+
+func newFragmentAssembler(timeout time.Duration) *FragmentAssembler {
+	return &FragmentAssembler{
+		timeout: timeout,
+		pending: make(map[fragmentKey]*fragmentGroup),
+	}
+}
+
+// SetTimeout changes how long a partial message may wait for its next part.
+func (fa *FragmentAssembler) SetTimeout(timeout time.Duration) {
+	fa.mu.Lock()
+	defer fa.mu.Unlock()
+	fa.timeout = timeout
+}
+
+// Add feeds one sentence from source into the assembler. Once a message is
+// complete it returns a single-sentence equivalent suitable for decoding,
+// together with the original sentences that made it up. ok is false while
+// more parts are awaited or when the sentence was dropped.
+// Sentences that are not multipart are returned unchanged.
+func (fa *FragmentAssembler) Add(sentence, source string) (assembled string, parts []string, ok bool) {
+	body := sentence
+	if star := strings.LastIndexByte(body, '*'); star >= 0 {
+		body = body[:star]
+	}
+	fields := strings.Split(body, ",")
+	if len(fields) < 7 || fields[1] == "1" || !strings.HasPrefix(fields[0], "!") {
+		return sentence, []string{sentence}, true
+	}
+	total, err1 := strconv.Atoi(fields[1])
+	num, err2 := strconv.Atoi(fields[2])
+	if err1 != nil || err2 != nil || total < 1 || num < 1 || num > total {
+		return sentence, []string{sentence}, true
+	}
+
+	key := fragmentKey{source: source, channel: fields[4], seqID: fields[3]}
+	now := time.Now()
+
+	fa.mu.Lock()
+	defer fa.mu.Unlock()
+	fa.expireLocked(now)
+
+	group, exists := fa.pending[key]
+	if num == 1 {
+		if exists {
+			// A new message reused the ID before the previous one finished.
+			fa.incomplete++
+			recordSentenceError(source, reasonFragmentMismatch)
+		}
+		group = &fragmentGroup{formatter: fields[0], total: total, started: now}
+		fa.pending[key] = group
+	} else if !exists || group.total != total || len(group.parts) != num-1 {
+		fa.orphaned++
+		recordSentenceError(source, reasonFragmentMismatch)
+		if exists {
+			fa.incomplete++
+			delete(fa.pending, key)
+		}
+		return "", nil, false
+	}
+
+	group.parts = append(group.parts, sentence)
+	group.payload.WriteString(fields[5])
+	group.fillBits = fields[6]
+	if len(group.parts) < group.total {
+		return "", nil, false
+	}
+	delete(fa.pending, key)
+
+	assembledBody := fmt.Sprintf("%s,1,1,,%s,%s,%s", group.formatter, fields[4], group.payload.String(), group.fillBits)
+	return fmt.Sprintf("%s*%02X", assembledBody, nmeaChecksum(assembledBody)), group.parts, true
+}
+
+// expireLocked drops groups that have waited longer than the timeout.
+func (fa *FragmentAssembler) expireLocked(now time.Time) {
+	for key, group := range fa.pending {
+		if now.Sub(group.started) > fa.timeout {
+			fa.incomplete++
+			delete(fa.pending, key)
+		}
+	}
+}
+
+// Stats returns the incomplete and orphaned fragment counters.
+func (fa *FragmentAssembler) Stats() (incomplete, orphaned int) {
+	fa.mu.Lock()
+	defer fa.mu.Unlock()
+	fa.expireLocked(time.Now())
+	return fa.incomplete, fa.orphaned
+}
+
+// nmeaChecksum XORs the characters between the leading '!' or '$' and the
+// end of body (which must not include the '*').
+func nmeaChecksum(body string) byte {
+	var sum byte
+	for i := 1; i < len(body); i++ {
+		sum ^= body[i]
+	}
+	return sum
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// maxIngestBodySize bounds a batch as sent; maxIngestBatchSize bounds it
+	// after gzip decoding.
+	maxIngestBodySize  = 16 << 20
+	maxIngestBatchSize = 64 << 20
+)
+
+var ingestCounter SlidingWindowCounter
+
+// ingestReceiverUUID authenticates a POST /ingest request. The receiver UUID
+// is sent as the basic auth username (curl -u <uuid>:) and must be listed in
+// allowed-uuids.json unless allowAll is set.
+func ingestReceiverUUID(r *http.Request, stateDir string, allowAll bool) (string, error) {
+	receiverUUID, _, ok := r.BasicAuth()
+	if !ok || strings.TrimSpace(receiverUUID) == "" {
+		return "", fmt.Errorf("missing receiver UUID")
+	}
+	if _, err := uuid.Parse(receiverUUID); err != nil {
+		return "", fmt.Errorf("invalid UUID format")
+	}
+	if allowAll {
+		return receiverUUID, nil
+	}
+	allowedData, err := os.ReadFile(filepath.Join(stateDir, "allowed-uuids.json"))
+	if err != nil {
+		return "", fmt.Errorf("allowed UUIDs file not found")
+	}
+	var allowedList []string
+	if err := json.Unmarshal(allowedData, &allowedList); err != nil {
+		return "", fmt.Errorf("invalid allowed UUIDs file")
+	}
+	for _, allowed := range allowedList {
+		if receiverUUID == allowed {
+			return receiverUUID, nil
+		}
+	}
+	return "", fmt.Errorf("receiver UUID not in allowed list")
+}
+
+// readIngestBatch returns the lines of a newline-delimited NMEA batch,
+// decoding gzip when the request says so or the body starts with the gzip
+// magic bytes.
+func readIngestBatch(r *http.Request) ([]string, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxIngestBodySize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxIngestBodySize {
+		return nil, fmt.Errorf("batch larger than %d bytes", maxIngestBodySize)
+	}
+	var reader io.Reader = bytes.NewReader(body)
+	if strings.EqualFold(r.Header.Get("Content-Encoding"), "gzip") || bytes.HasPrefix(body, []byte{0x1f, 0x8b}) {
+		zr, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, fmt.Errorf("invalid gzip body: %w", err)
+		}
+		defer zr.Close()
+		reader = io.LimitReader(zr, maxIngestBatchSize)
+	}
+
+	var lines []string
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading batch: %w", err)
+	}
+	return lines, nil
+}
+
+// ingestRejectReason checks one batch line, including any leading tag block.
+// It returns false for lines to reject, with the sentence_errors reason, or
+// "" when the line is not NMEA at all.
+func ingestRejectReason(line string) (string, bool) {
+	_, sentence, err := parseTagBlock(line)
+	if err != nil {
+		return reasonBadChecksum, false
+	}
+	if len(sentence) == 0 || (sentence[0] != '!' && sentence[0] != '$') {
+		return "", false
+	}
+	if reason := checkSentence(sentence); reason != "" {
+		return reason, false
+	}
+	return "", true
+}
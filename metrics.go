@@ -78,6 +78,56 @@ func (ma *MaxAggregator) reset() {
     ma.Max = 0
 }
 
+// CounterDeltaAggregator turns cumulative source -> reason counters into the
+// counts seen during the current aggregation period.
+type CounterDeltaAggregator struct {
+	Start  map[string]map[string]int `json:"start"`
+	Latest map[string]map[string]int `json:"latest"`
+}
+
+func sumCounters(counters map[string]map[string]int) int {
+	total := 0
+	for _, counts := range counters {
+		for _, n := range counts {
+			total += n
+		}
+	}
+	return total
+}
+
+func (cd *CounterDeltaAggregator) update(latest map[string]map[string]int) {
+	// Counters that went backwards were restarted, so count from zero.
+	if cd.Start == nil || sumCounters(latest) < sumCounters(cd.Start) {
+		cd.Start = make(map[string]map[string]int)
+	}
+	cd.Latest = latest
+}
+
+// delta returns the counts for the period, per source and per reason.
+func (cd *CounterDeltaAggregator) delta() (bySource map[string]map[string]int, byReason map[string]int) {
+	bySource = make(map[string]map[string]int)
+	byReason = make(map[string]int)
+	for source, counts := range cd.Latest {
+		for reason, n := range counts {
+			d := n - cd.Start[source][reason]
+			if d <= 0 {
+				continue
+			}
+			if bySource[source] == nil {
+				bySource[source] = make(map[string]int)
+			}
+			bySource[source][reason] = d
+			byReason[reason] += d
+		}
+	}
+	return bySource, byReason
+}
+
+// reset starts the next period from the latest counts.
+func (cd *CounterDeltaAggregator) reset() {
+	cd.Start = cd.Latest
+}
+
 // defaultMetricsAggregate creates a default snapshot with zero averages.
 func defaultMetricsAggregate() MetricsAggregate {
 	return MetricsAggregate{
@@ -86,8 +136,16 @@ func defaultMetricsAggregate() MetricsAggregate {
 		UDPMessagesPerSec:     AggregatedMetric{Ave: 0},
 		SerialMessagesPerMin:  AggregatedMetric{Ave: 0},
 		UDPMessagesPerMin:     AggregatedMetric{Ave: 0},
+		TCPMessagesPerSec:     AggregatedMetric{Ave: 0},
+		TCPMessagesPerMin:     AggregatedMetric{Ave: 0},
+		AISCatcherMessagesPerSec: AggregatedMetric{Ave: 0},
+		AISCatcherMessagesPerMin: AggregatedMetric{Ave: 0},
+		IngestMessagesPerSec:  AggregatedMetric{Ave: 0},
+		IngestMessagesPerMin:  AggregatedMetric{Ave: 0},
 		TotalDeduplications:   AggregatedMetric{Ave: 0},
 		ActiveWebSockets:      AggregatedMetric{Ave: 0},
+		TCPServerClients:      AggregatedMetric{Ave: 0},
+		QueueDepth:            AggregatedMetric{Ave: 0},
 		NumVesselsClassA:      AggregatedMetric{Ave: 0},
 		NumVesselsClassB:      AggregatedMetric{Ave: 0},
 		NumVesselsAtoN:        AggregatedMetric{Ave: 0},
@@ -109,8 +167,17 @@ type MetricsAggregator struct {
 	UDPMessagesPerSec      NumericAggregator `json:"udp_messages_per_sec"`
 	SerialMessagesPerMin   NumericAggregator `json:"serial_messages_per_min"`
 	UDPMessagesPerMin      NumericAggregator `json:"udp_messages_per_min"`
+	TCPMessagesPerSec      NumericAggregator `json:"tcp_messages_per_sec"`
+	TCPMessagesPerMin      NumericAggregator `json:"tcp_messages_per_min"`
+	AISCatcherMessagesPerSec NumericAggregator `json:"aiscatcher_messages_per_sec"`
+	AISCatcherMessagesPerMin NumericAggregator `json:"aiscatcher_messages_per_min"`
+	IngestMessagesPerSec   NumericAggregator `json:"ingest_messages_per_sec"`
+	IngestMessagesPerMin   NumericAggregator `json:"ingest_messages_per_min"`
 	TotalDeduplications    NumericAggregator `json:"total_deduplications"`
 	ActiveWebSockets       NumericAggregator `json:"active_websockets"`
+	TCPServerClients       NumericAggregator `json:"tcp_server_clients"`
+	QueueDepth             NumericAggregator `json:"queue_depth"`
+	SentenceErrors         CounterDeltaAggregator `json:"sentence_errors"`
 	NumVesselsClassA       NumericAggregator `json:"num_vessels_class_a"`
 	NumVesselsClassB       NumericAggregator `json:"num_vessels_class_b"`
 	NumVesselsAtoN         NumericAggregator `json:"num_vessels_aton"`
@@ -128,8 +195,17 @@ func (ma *MetricsAggregator) update(m Metrics) {
 	ma.UDPMessagesPerSec.update(m.UDPMessagesPerSec)
 	ma.SerialMessagesPerMin.update(m.SerialMessagesPerMin)
 	ma.UDPMessagesPerMin.update(m.UDPMessagesPerMin)
+	ma.TCPMessagesPerSec.update(m.TCPMessagesPerSec)
+	ma.TCPMessagesPerMin.update(m.TCPMessagesPerMin)
+	ma.AISCatcherMessagesPerSec.update(m.AISCatcherMessagesPerSec)
+	ma.AISCatcherMessagesPerMin.update(m.AISCatcherMessagesPerMin)
+	ma.IngestMessagesPerSec.update(m.IngestMessagesPerSec)
+	ma.IngestMessagesPerMin.update(m.IngestMessagesPerMin)
 	ma.TotalDeduplications.update(float64(m.TotalDeduplications))
 	ma.ActiveWebSockets.update(float64(m.ActiveWebSockets))
+	ma.TCPServerClients.update(float64(m.TCPServerClients))
+	ma.QueueDepth.update(float64(m.QueueDepth))
+	ma.SentenceErrors.update(m.SentenceErrors)
 	ma.NumVesselsClassA.update(float64(m.NumVesselsClassA))
 	ma.NumVesselsClassB.update(float64(m.NumVesselsClassB))
 	ma.NumVesselsAtoN.update(float64(m.NumVesselsAtoN))
@@ -148,8 +224,18 @@ type MetricsAggregate struct {
 	UDPMessagesPerSec     AggregatedMetric `json:"udp_messages_per_sec"`
 	SerialMessagesPerMin  AggregatedMetric `json:"serial_messages_per_min"`
 	UDPMessagesPerMin     AggregatedMetric `json:"udp_messages_per_min"`
+	TCPMessagesPerSec     AggregatedMetric `json:"tcp_messages_per_sec"`
+	TCPMessagesPerMin     AggregatedMetric `json:"tcp_messages_per_min"`
+	AISCatcherMessagesPerSec AggregatedMetric `json:"aiscatcher_messages_per_sec"`
+	AISCatcherMessagesPerMin AggregatedMetric `json:"aiscatcher_messages_per_min"`
+	IngestMessagesPerSec  AggregatedMetric `json:"ingest_messages_per_sec"`
+	IngestMessagesPerMin  AggregatedMetric `json:"ingest_messages_per_min"`
 	TotalDeduplications   AggregatedMetric `json:"total_deduplications"`
 	ActiveWebSockets      AggregatedMetric `json:"active_websockets"`
+	TCPServerClients      AggregatedMetric `json:"tcp_server_clients"`
+	QueueDepth            AggregatedMetric `json:"queue_depth"`
+	SentenceErrors        map[string]map[string]int `json:"sentence_errors,omitempty"`        // counts during the period
+	SentenceErrorReasons  map[string]int            `json:"sentence_error_reasons,omitempty"` // counts during the period
 	NumVesselsClassA      AggregatedMetric `json:"num_vessels_class_a"`
 	NumVesselsClassB      AggregatedMetric `json:"num_vessels_class_b"`
 	NumVesselsAtoN        AggregatedMetric `json:"num_vessels_aton"`
@@ -165,6 +251,7 @@ type MetricsAggregate struct {
 // finalize produces a snapshot from the aggregator using its own StartTime,
 // rounding each average to ensure whole-number output.
 func (ma *MetricsAggregator) finalize() MetricsAggregate {
+	sentenceErrors, sentenceErrorReasons := ma.SentenceErrors.delta()
 	return MetricsAggregate{
 		Timestamp: time.Now().UTC(),
 		SerialMessagesPerSec: AggregatedMetric{
@@ -179,12 +266,38 @@ func (ma *MetricsAggregator) finalize() MetricsAggregate {
 		UDPMessagesPerMin: AggregatedMetric{
 			Ave: math.Round(ma.UDPMessagesPerMin.average()),
 		},
+		TCPMessagesPerSec: AggregatedMetric{
+			Ave: math.Round(ma.TCPMessagesPerSec.average()),
+		},
+		TCPMessagesPerMin: AggregatedMetric{
+			Ave: math.Round(ma.TCPMessagesPerMin.average()),
+		},
+		AISCatcherMessagesPerSec: AggregatedMetric{
+			Ave: math.Round(ma.AISCatcherMessagesPerSec.average()),
+		},
+		AISCatcherMessagesPerMin: AggregatedMetric{
+			Ave: math.Round(ma.AISCatcherMessagesPerMin.average()),
+		},
+		IngestMessagesPerSec: AggregatedMetric{
+			Ave: math.Round(ma.IngestMessagesPerSec.average()),
+		},
+		IngestMessagesPerMin: AggregatedMetric{
+			Ave: math.Round(ma.IngestMessagesPerMin.average()),
+		},
 		TotalDeduplications: AggregatedMetric{
 			Ave: math.Round(ma.TotalDeduplications.average()),
 		},
 		ActiveWebSockets: AggregatedMetric{
 			Ave: math.Round(ma.ActiveWebSockets.average()),
 		},
+		TCPServerClients: AggregatedMetric{
+			Ave: math.Round(ma.TCPServerClients.average()),
+		},
+		QueueDepth: AggregatedMetric{
+			Ave: math.Round(ma.QueueDepth.average()),
+		},
+		SentenceErrors:       sentenceErrors,
+		SentenceErrorReasons: sentenceErrorReasons,
 		NumVesselsClassA: AggregatedMetric{
 			Ave: math.Round(ma.NumVesselsClassA.average()),
 		},
@@ -220,8 +333,17 @@ func (ma *MetricsAggregator) reset() {
 	ma.UDPMessagesPerSec.reset()
 	ma.SerialMessagesPerMin.reset()
 	ma.UDPMessagesPerMin.reset()
+	ma.TCPMessagesPerSec.reset()
+	ma.TCPMessagesPerMin.reset()
+	ma.AISCatcherMessagesPerSec.reset()
+	ma.AISCatcherMessagesPerMin.reset()
+	ma.IngestMessagesPerSec.reset()
+	ma.IngestMessagesPerMin.reset()
 	ma.TotalDeduplications.reset()
 	ma.ActiveWebSockets.reset()
+	ma.TCPServerClients.reset()
+	ma.QueueDepth.reset()
+	ma.SentenceErrors.reset()
 	ma.NumVesselsClassA.reset()
 	ma.NumVesselsClassB.reset()
 	ma.NumVesselsAtoN.reset()
@@ -319,11 +441,17 @@ func loadAggregatorsState(stateDir string) error {
 // -----------------------------------------------------------------------------
 // getCurrentMetrics collects the current live metrics.
 // It uses calculateVesselCounts() from aisdecode.go and other global variables
-// (vesselData, serialCounter, udpCounter, totalMessages, dedupeMessages, clients, etc.)
+// (vesselData, serialCounter, udpCounter, tcpCounter, totalMessages, dedupeMessages, clients, etc.)
 func getCurrentMetrics() Metrics {
     counts := calculateVesselCounts()
     totalKnown := len(vesselData)
     uptimeSeconds := int(time.Since(startTime).Seconds())
+    tcpServerClients, tcpServerDropped := nmeaServer.Stats()
+    incompleteFragments, orphanedFragments := fragmentAssembler.Stats()
+    sentenceErrorsBySource, sentenceErrorsByReason := sentenceErrorMetrics()
+    queueDepth, queueCapacity, queueDropped := pipeline.QueueStats()
+    dedupeSetSize, dedupeSources := dedupeSet.Stats()
+    baseStationClocks, receiverClocks := clockMetrics()
 
     // Compute the rolling 1-minute metrics for distance.
     var rollingSum float64
@@ -361,9 +489,32 @@ func getCurrentMetrics() Metrics {
         SerialMessagesPerMin:    float64(serialCounter.Count(1 * time.Minute)),
         UDPMessagesPerSec:       float64(udpCounter.Count(1 * time.Second)),
         UDPMessagesPerMin:       float64(udpCounter.Count(1 * time.Minute)),
-        TotalMessages:           totalMessages,
-        TotalDeduplications:     dedupeMessages,
+        TCPMessagesPerSec:       float64(tcpCounter.Count(1 * time.Second)),
+        TCPMessagesPerMin:       float64(tcpCounter.Count(1 * time.Minute)),
+        AISCatcherMessagesPerSec: float64(aisCatcherCounter.Count(1 * time.Second)),
+        AISCatcherMessagesPerMin: float64(aisCatcherCounter.Count(1 * time.Minute)),
+        IngestMessagesPerSec:    float64(ingestCounter.Count(1 * time.Second)),
+        IngestMessagesPerMin:    float64(ingestCounter.Count(1 * time.Minute)),
+        SerialPorts:             serialPortMetrics(),
+        ReceptionQuality:        receptionQualityMetrics(),
+        SentenceErrors:          sentenceErrorsBySource,
+        SentenceErrorReasons:    sentenceErrorsByReason,
+        TotalMessages:           int(totalMessages.Load()),
+        TotalDeduplications:     int(dedupeMessages.Load()),
+        DedupeSetSize:           dedupeSetSize,
+        DedupeSources:           dedupeSources,
+        BaseStationClocks:       baseStationClocks,
+        ReceiverClocks:          receiverClocks,
+        UDPTruncatedLines:       int(udpTruncatedLines.Load()),
+        UDPMalformedLines:       int(udpMalformedLines.Load()),
+        IncompleteFragments:     incompleteFragments,
+        OrphanedFragments:       orphanedFragments,
         ActiveWebSockets:        len(clients),
+        TCPServerClients:        tcpServerClients,
+        TCPServerDropped:        tcpServerDropped,
+        QueueDepth:              queueDepth,
+        QueueCapacity:           queueCapacity,
+        QueueDropped:            queueDropped,
         NumVesselsClassA:        counts["Class A"],
         NumVesselsClassB:        counts["Class B"],
         NumVesselsAtoN:          counts["AtoN"],
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Reasons a sentence is rejected, as reported in the sentence_errors metrics.
+const (
+	reasonBadChecksum      = "bad_checksum"
+	reasonUnknownTalker    = "unknown_talker"
+	reasonBadArmouring     = "bad_armouring"
+	reasonFragmentMismatch = "fragment_mismatch"
+	reasonUnsupportedType  = "unsupported_type"
+)
+
+// sentenceErrorReasons lists every reason, so metrics always report all of them.
+var sentenceErrorReasons = []string{
+	reasonBadChecksum,
+	reasonUnknownTalker,
+	reasonBadArmouring,
+	reasonFragmentMismatch,
+	reasonUnsupportedType,
+}
+
+// aisTalkerIDs are the talker IDs IEC 61162-1 assigns to AIS equipment.
+var aisTalkerIDs = map[string]bool{
+	"AB": true, // independent AIS base station
+	"AD": true, // dependent AIS base station
+	"AI": true, // mobile AIS station
+	"AN": true, // AIS aid to navigation
+	"AR": true, // AIS receiving station
+	"AS": true, // AIS limited base station
+	"AT": true, // AIS transmitting station
+	"AX": true, // AIS simplex repeater
+	"BS": true, // base station (deprecated)
+	"SA": true, // physical shore AIS station
+}
+
+var (
+	sentenceErrorsMutex sync.Mutex
+	sentenceErrors      = make(map[string]map[string]int) // source -> reason -> count
+)
+
+// recordSentenceError counts one rejected sentence from source.
+func recordSentenceError(source, reason string) {
+	sentenceErrorsMutex.Lock()
+	defer sentenceErrorsMutex.Unlock()
+	bySource, ok := sentenceErrors[source]
+	if !ok {
+		bySource = make(map[string]int)
+		sentenceErrors[source] = bySource
+	}
+	bySource[reason]++
+}
+
+// sentenceErrorMetrics returns a copy of the per-source counts and the
+// totals per reason.
+func sentenceErrorMetrics() (bySource map[string]map[string]int, byReason map[string]int) {
+	sentenceErrorsMutex.Lock()
+	defer sentenceErrorsMutex.Unlock()
+	bySource = make(map[string]map[string]int, len(sentenceErrors))
+	byReason = make(map[string]int, len(sentenceErrorReasons))
+	for _, reason := range sentenceErrorReasons {
+		byReason[reason] = 0
+	}
+	for source, counts := range sentenceErrors {
+		copied := make(map[string]int, len(counts))
+		for reason, n := range counts {
+			copied[reason] = n
+			byReason[reason] += n
+		}
+		bySource[source] = copied
+	}
+	return bySource, byReason
+}
+
+// checkSentence validates a bare sentence (tag block already removed) before
+// it reaches the fragment assembler and decoder. It returns the rejection
+// reason, or "" if the sentence looks sound.
+func checkSentence(sentence string) string {
+	star := strings.LastIndexByte(sentence, '*')
+	if star < 0 {
+		return reasonBadChecksum
+	}
+	want, err := strconv.ParseUint(strings.TrimSpace(sentence[star+1:]), 16, 8)
+	if err != nil || byte(want) != nmeaChecksum(sentence[:star]) {
+		return reasonBadChecksum
+	}
+	if !strings.HasPrefix(sentence, "!") {
+		// Other $ sentences are only checksummed; what they contain is up
+		// to the input that handles them.
+		return ""
+	}
+
+	fields := strings.Split(sentence[:star], ",")
+	if len(fields[0]) != 6 {
+		return reasonUnknownTalker
+	}
+	if !aisTalkerIDs[fields[0][1:3]] {
+		return reasonUnknownTalker
+	}
+	if formatter := fields[0][3:]; formatter != "VDM" && formatter != "VDO" {
+		return reasonUnsupportedType
+	}
+	if len(fields) != 7 {
+		return reasonBadArmouring
+	}
+	for i := 0; i < len(fields[5]); i++ {
+		if _, ok := sixbitValue(fields[5][i]); !ok {
+			return reasonBadArmouring
+		}
+	}
+	if fill, err := strconv.Atoi(fields[6]); err != nil || fill < 0 || fill > 5 {
+		return reasonBadArmouring
+	}
+	return ""
+}
+
+// decodeErrorReason explains why the codec could not decode a sentence that
+// passed checkSentence: either the message type is not one we decode, or the
+// payload does not have the length its type requires.
+func decodeErrorReason(sentence string) string {
+	if !strings.HasPrefix(sentence, "!") {
+		return reasonUnsupportedType
+	}
+	body := sentence
+	if star := strings.LastIndexByte(body, '*'); star >= 0 {
+		body = body[:star]
+	}
+	fields := strings.Split(body, ",")
+	if len(fields) < 6 || fields[5] == "" {
+		return reasonBadArmouring
+	}
+	msgType, ok := sixbitValue(fields[5][0])
+	if !ok {
+		return reasonBadArmouring
+	}
+	if msgType < 1 || msgType > 27 {
+		return reasonUnsupportedType
+	}
+	return reasonBadArmouring
+}
+
+// sixbitValue de-armours one AIS payload character.
+func sixbitValue(c byte) (byte, bool) {
+	switch {
+	case c >= '0' && c <= 'W':
+		return c - '0', true
+	case c >= '`' && c <= 'w':
+		return c - '0' - 8, true
+	}
+	return 0, false
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// nmeaClientBuffer is the number of sentences queued per client before
+	// new sentences are dropped for that client.
+	nmeaClientBuffer       = 1024
+	nmeaClientWriteTimeout = 10 * time.Second
+)
+
+// nmeaServerClient is a single downstream consumer (OpenCPN, plotter, ...).
+type nmeaServerClient struct {
+	conn net.Conn
+	out  chan string
+	done chan struct{}
+}
+
+// NMEAServer re-serves accepted raw sentences to any number of TCP clients.
+// Each client has its own buffered queue and writer goroutine, so a slow
+// consumer only loses its own sentences and never stalls the readers.
+type NMEAServer struct {
+	mu      sync.Mutex
+	clients map[*nmeaServerClient]struct{}
+	dropped int
+}
+
+// nmeaServer is nil unless -tcp-server-port is set.
+var nmeaServer *NMEAServer
+
+func newNMEAServer() *NMEAServer {
+	return &NMEAServer{clients: make(map[*nmeaServerClient]struct{})}
+}
+
+// ListenAndServe accepts clients on the given port until the listener fails.
+func (s *NMEAServer) ListenAndServe(port int) error {
+	ln, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return err
+	}
+	log.Printf("Serving NMEA feed to TCP clients on %s", ln.Addr().String())
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		client := &nmeaServerClient{
+			conn: conn,
+			out:  make(chan string, nmeaClientBuffer),
+			done: make(chan struct{}),
+		}
+		s.mu.Lock()
+		s.clients[client] = struct{}{}
+		s.mu.Unlock()
+		log.Printf("NMEA TCP client connected: %s", conn.RemoteAddr().String())
+		// Anything the client sends is discarded; a read error means it left.
+		go func() {
+			io.Copy(io.Discard, conn)
+			close(client.done)
+		}()
+		go s.serveClient(client)
+	}
+}
+
+// serveClient writes queued sentences to the client until it goes away.
+func (s *NMEAServer) serveClient(client *nmeaServerClient) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.clients, client)
+		s.mu.Unlock()
+		client.conn.Close()
+		log.Printf("NMEA TCP client disconnected: %s", client.conn.RemoteAddr().String())
+	}()
+	for {
+		select {
+		case line := <-client.out:
+			client.conn.SetWriteDeadline(time.Now().Add(nmeaClientWriteTimeout))
+			if _, err := client.conn.Write([]byte(line + "\r\n")); err != nil {
+				return
+			}
+		case <-client.done:
+			return
+		}
+	}
+}
+
+// Broadcast queues a sentence for every connected client without blocking.
+func (s *NMEAServer) Broadcast(line string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for client := range s.clients {
+		select {
+		case client.out <- line:
+		default:
+			s.dropped++
+		}
+	}
+}
+
+// RawSentence makes NMEAServer a pipeline Sink: every deduplicated sentence
+// is re-served as received.
+func (s *NMEAServer) RawSentence(msg *Sentence) {
+	for _, part := range msg.Parts {
+		s.Broadcast(part)
+	}
+}
+
+func (s *NMEAServer) Decoded(msg *DecodedMessage) {}
+
+// Stats returns the number of connected clients and sentences dropped so far.
+func (s *NMEAServer) Stats() (clients int, dropped int) {
+	if s == nil {
+		return 0, 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.clients), s.dropped
+}
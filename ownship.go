@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ownShipFixMaxAge is how long an own-ship fix is trusted before the static
+// myinfo.json coordinates are used again.
+const ownShipFixMaxAge = 2 * time.Minute
+
+// OwnShip is the installation's own position, taken from !AIVDO reports and
+// GPS fixes on a serial port, as served by /ownship.
+type OwnShip struct {
+	UserID    string   `json:"user_id,omitempty"` // MMSI from !AIVDO, if seen
+	Latitude  float64  `json:"latitude"`
+	Longitude float64  `json:"longitude"`
+	Sog       *float64 `json:"sog,omitempty"`
+	Cog       *float64 `json:"cog,omitempty"`
+	FixSource string   `json:"fix_source"` // e.g. AIVDO, GPRMC, GNGGA
+	Port      string   `json:"port"`       // serial port label
+	LastFix   string   `json:"last_fix"`
+	Fresh     bool     `json:"fresh"`
+}
+
+var (
+	ownShipMutex   sync.Mutex
+	ownShip        OwnShip
+	ownShipFixTime time.Time
+)
+
+// gpsFix is a position decoded from an RMC or GGA sentence.
+type gpsFix struct {
+	Sentence  string // e.g. GPRMC
+	Latitude  float64
+	Longitude float64
+	Sog       *float64
+	Cog       *float64
+}
+
+// isOwnShipSentence reports whether sentence is an !xxVDO own-ship report.
+func isOwnShipSentence(sentence string) bool {
+	return len(sentence) >= 6 && sentence[0] == '!' && sentence[3:6] == "VDO"
+}
+
+// parseGPSFix extracts the position from an RMC or GGA sentence from any
+// talker (GP, GN, GL, ...). ok is false for other sentences, bad checksums
+// and sentences reporting no fix.
+func parseGPSFix(sentence string) (fix gpsFix, ok bool) {
+	body := sentence
+	if star := strings.LastIndexByte(body, '*'); star >= 0 {
+		want, err := strconv.ParseUint(strings.TrimSpace(body[star+1:]), 16, 8)
+		if err != nil || byte(want) != nmeaChecksum(body[:star]) {
+			return fix, false
+		}
+		body = body[:star]
+	}
+	fields := strings.Split(body, ",")
+	if len(fields[0]) != 6 || fields[0][0] != '$' {
+		return fix, false
+	}
+	fix.Sentence = fields[0][1:]
+
+	var latField, latHemi, lonField, lonHemi string
+	switch fix.Sentence[2:] {
+	case "RMC":
+		if len(fields) < 9 || fields[2] != "A" {
+			return fix, false
+		}
+		latField, latHemi, lonField, lonHemi = fields[3], fields[4], fields[5], fields[6]
+		if sog, err := strconv.ParseFloat(fields[7], 64); err == nil {
+			fix.Sog = &sog
+		}
+		if cog, err := strconv.ParseFloat(fields[8], 64); err == nil {
+			fix.Cog = &cog
+		}
+	case "GGA":
+		if len(fields) < 7 || fields[6] == "" || fields[6] == "0" {
+			return fix, false
+		}
+		latField, latHemi, lonField, lonHemi = fields[2], fields[3], fields[4], fields[5]
+	default:
+		return fix, false
+	}
+
+	var err error
+	if fix.Latitude, err = parseNMEACoordinate(latField, latHemi, "N", "S"); err != nil {
+		return fix, false
+	}
+	if fix.Longitude, err = parseNMEACoordinate(lonField, lonHemi, "E", "W"); err != nil {
+		return fix, false
+	}
+	return fix, true
+}
+
+// parseNMEACoordinate converts a (d)ddmm.mmmm value and hemisphere letter to
+// signed decimal degrees.
+func parseNMEACoordinate(value, hemi, positive, negative string) (float64, error) {
+	dot := strings.IndexByte(value, '.')
+	if dot < 0 {
+		dot = len(value)
+	}
+	if dot < 3 {
+		return 0, fmt.Errorf("invalid coordinate %q", value)
+	}
+	degrees, err := strconv.ParseFloat(value[:dot-2], 64)
+	if err != nil {
+		return 0, err
+	}
+	minutes, err := strconv.ParseFloat(value[dot-2:], 64)
+	if err != nil || minutes >= 60 {
+		return 0, fmt.Errorf("invalid coordinate %q", value)
+	}
+	coord := degrees + minutes/60
+	switch hemi {
+	case positive:
+	case negative:
+		coord = -coord
+	default:
+		return 0, fmt.Errorf("invalid hemisphere %q", hemi)
+	}
+	return coord, nil
+}
+
+// updateOwnShipFix records a new own-ship position. userID is empty for GPS
+// fixes, which keep the MMSI learned from earlier !AIVDO reports.
+func updateOwnShipFix(userID string, lat, lon float64, sog, cog *float64, fixSource, port string, at time.Time) {
+	if math.Abs(lat) > 90 || math.Abs(lon) > 180 {
+		return
+	}
+	ownShipMutex.Lock()
+	defer ownShipMutex.Unlock()
+	if userID != "" {
+		ownShip.UserID = userID
+	}
+	ownShip.Latitude = lat
+	ownShip.Longitude = lon
+	ownShip.Sog = sog
+	ownShip.Cog = cog
+	ownShip.FixSource = fixSource
+	ownShip.Port = port
+	ownShip.LastFix = at.UTC().Format(time.RFC3339Nano)
+	ownShipFixTime = at
+}
+
+// ownShipPosition returns the own-ship position if a fix is recent enough.
+func ownShipPosition() (lat, lon float64, ok bool) {
+	ownShipMutex.Lock()
+	defer ownShipMutex.Unlock()
+	if ownShipFixTime.IsZero() || clockNow().Sub(ownShipFixTime) > ownShipFixMaxAge {
+		return 0, 0, false
+	}
+	return ownShip.Latitude, ownShip.Longitude, true
+}
+
+// ownShipSnapshot returns the current own-ship state, and false if no fix
+// has been received yet.
+func ownShipSnapshot() (OwnShip, bool) {
+	ownShipMutex.Lock()
+	defer ownShipMutex.Unlock()
+	if ownShipFixTime.IsZero() {
+		return OwnShip{}, false
+	}
+	snapshot := ownShip
+	snapshot.Fresh = clockNow().Sub(ownShipFixTime) <= ownShipFixMaxAge
+	return snapshot, true
+}
@@ -0,0 +1,455 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	ais "github.com/BertoldVdb/go-ais"
+	"github.com/BertoldVdb/go-ais/aisnmea"
+)
+
+// Source is an input of NMEA sentences: a serial port, the UDP listener, a TCP
+// feed, a replay file, ... Run blocks for as long as the input lasts and
+// passes every line it reads to emit, labelled with where it came from.
+type Source interface {
+	Name() string
+	Run(emit func(rawNmea, source string))
+}
+
+// waitingSource is implemented by sources that would rather wait for the
+// queue than lose sentences, such as a replay running flat out.
+type waitingSource interface {
+	WaitsForQueue() bool
+}
+
+// ownShipSource is implemented by sources attached to the installation's own
+// transponder or GPS, whose !AIVDO and $GPRMC/$GPGGA sentences describe us
+// rather than another station.
+type ownShipSource interface {
+	CarriesOwnShip() bool
+}
+
+// Sentence is one message that passed validation, reassembly and dedupe.
+type Sentence struct {
+	Source     string         // input label, e.g. UDP peer address or serial port name
+	Station    string         // tag block s: station, if any
+	ReceivedAt time.Time      // tag block c: time, else when it arrived
+	ArrivedAt  time.Time      // when it arrived here
+	Signal     *SignalQuality // reported by AIS-catcher, else nil
+	Parts      []string       // sentences as received, without tag blocks
+	Assembled  string         // single-sentence equivalent used for decoding
+}
+
+// DecodedMessage is a Sentence after decoding and merging into vessel state.
+type DecodedMessage struct {
+	*Sentence
+	VesselID string
+	Packet   ais.Packet // decoded go-ais packet
+	Data     *Vessel    // fields from this message alone
+	Vessel   *Vessel    // merged vessel record
+	JSON     []byte     // AISMessage as sent to the ais_data room
+	// Application is the decoded content of a type 6 or 8 message with a
+	// known DAC/FI, else nil.
+	Application *BinaryApplication
+	OwnShip     bool // !AIVDO from an own-ship source
+}
+
+// Sink is an output of the pipeline. RawSentence sees every deduplicated
+// sentence before decoding; Decoded sees every decoded message after the
+// vessel record has been merged.
+type Sink interface {
+	RawSentence(msg *Sentence)
+	Decoded(msg *DecodedMessage)
+}
+
+// Pipeline is the processing stage shared by every Source. The reading
+// goroutine only validates tag blocks and checksums, reassembles multipart
+// messages and drops duplicates; the rest (decode, vessel merge, Sinks) runs
+// on a pool of workers fed through a bounded queue. Messages are routed to
+// workers by MMSI, so updates to one vessel are applied in order.
+type Pipeline struct {
+	Debug             bool
+	ShowDecodes       bool
+	DedupeWindow      time.Duration // 0 disables dedupe
+	DedupeMode        string        // dedupeModeSentence or dedupeModePayload
+	ExpireAfter       time.Duration // window for the rolling NumMessages count
+	ExternalLookupURL string
+	StateDir          string
+
+	queues   []chan pipelineJob // one per worker
+	capacity int
+	dropped  int64 // accessed atomically
+
+	mu             sync.RWMutex
+	sinks          []Sink
+	ownShipSources map[string]bool
+}
+
+// pipeline is set up in main once the sinks are known.
+var pipeline *Pipeline
+
+// pipelineJob is a sentence waiting for a worker.
+type pipelineJob struct {
+	msg       *Sentence
+	sourceKey string
+}
+
+// newPipeline starts workers goroutines sharing a queue of queueSize
+// sentences. The AIS codec is not safe for concurrent use, so every worker
+// gets its own from newCodec.
+func newPipeline(newCodec func() *aisnmea.NMEACodec, workers, queueSize int) *Pipeline {
+	if workers < 1 {
+		workers = 1
+	}
+	perWorker := queueSize / workers
+	if perWorker < 1 {
+		perWorker = 1
+	}
+	p := &Pipeline{
+		queues:         make([]chan pipelineJob, workers),
+		capacity:       perWorker * workers,
+		ownShipSources: make(map[string]bool),
+	}
+	for i := range p.queues {
+		p.queues[i] = make(chan pipelineJob, perWorker)
+		go p.worker(p.queues[i], newCodec())
+	}
+	return p
+}
+
+// AddSink registers an output. Sinks are called in the order they were added.
+func (p *Pipeline) AddSink(sink Sink) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.sinks = append(p.sinks, sink)
+}
+
+// Run feeds src into the pipeline. It blocks for as long as src does. When
+// the queue is full, sentences from src are dropped (and counted) rather than
+// stalling the reader, unless src asks to wait.
+func (p *Pipeline) Run(src Source) {
+	if o, ok := src.(ownShipSource); ok && o.CarriesOwnShip() {
+		p.mu.Lock()
+		p.ownShipSources[src.Name()] = true
+		p.mu.Unlock()
+	}
+	emit := p.Offer
+	if w, ok := src.(waitingSource); ok && w.WaitsForQueue() {
+		emit = p.Process
+	}
+	src.Run(emit)
+}
+
+// Process runs one raw line from source through the pipeline, waiting for
+// room in the queue if necessary. It suits callers that can apply
+// backpressure, like an HTTP request.
+func (p *Pipeline) Process(rawNmea, source string) {
+	p.submit(rawNmea, source, nil, true)
+}
+
+// ProcessWithSignal is like Process for a sentence whose receiver reported
+// its signal quality. The quality is applied to the vessel only if the
+// sentence survives dedupe and decodes.
+func (p *Pipeline) ProcessWithSignal(rawNmea, source string, signal *SignalQuality) {
+	p.submit(rawNmea, source, signal, true)
+}
+
+// Offer is like Process but drops the sentence if the queue is full.
+func (p *Pipeline) Offer(rawNmea, source string) {
+	p.submit(rawNmea, source, nil, false)
+}
+
+// QueueStats returns the sentences waiting, the queue capacity and the
+// number dropped because the queue was full.
+func (p *Pipeline) QueueStats() (depth, capacity int, dropped int64) {
+	if p == nil {
+		return 0, 0, 0
+	}
+	for _, q := range p.queues {
+		depth += len(q)
+	}
+	return depth, p.capacity, atomic.LoadInt64(&p.dropped)
+}
+
+// submit does the cheap, order-sensitive work on the reader's goroutine and
+// queues the result for a worker.
+func (p *Pipeline) submit(rawNmea, source string, signal *SignalQuality, wait bool) {
+	rawArchive.Record(clockNow(), source, rawNmea)
+	// Split off any NMEA 4.10 tag block. Dedupe and decoding work on the
+	// bare sentence, so the same message relayed by two stations with
+	// different tag blocks is still recognised as a duplicate.
+	tagBlock, sentence, err := parseTagBlock(strings.TrimSpace(rawNmea))
+	if err != nil {
+		if p.Debug {
+			log.Printf("[DEBUG] Dropped sentence with bad tag block from %s: %v", source, err)
+		}
+		return
+	}
+	arrivedAt := clockNow()
+	receivedAt := tagBlock.receivedAt(arrivedAt)
+	sourceKey := tagBlock.sourceKey(source)
+	if reason := checkSentence(sentence); reason != "" {
+		recordSentenceError(sourceKey, reason)
+		if p.Debug {
+			log.Printf("[DEBUG] Rejected sentence from %s (%s): %s", source, reason, sentence)
+		}
+		return
+	}
+
+	// Reassemble multipart messages per source first, so dedupe and the
+	// decoder only ever see whole messages.
+	assembled, parts, ok := fragmentAssembler.Add(sentence, sourceKey)
+	if !ok {
+		return
+	}
+	if p.DedupeWindow > 0 && dedupeSet.Check(p.dedupeKey(assembled), sourceKey) {
+		if p.Debug {
+			log.Printf("[DEBUG] Dropped duplicate message from %s at %s: %s", source, time.Now().UTC().Format(time.RFC3339Nano), assembled)
+		}
+		dedupeMessages.Add(1)
+		return
+	}
+
+	msg := &Sentence{
+		Source:     source,
+		Station:    tagBlock.Source,
+		ReceivedAt: receivedAt,
+		ArrivedAt:  arrivedAt,
+		Signal:     signal,
+		Parts:      parts,
+		Assembled:  assembled,
+	}
+	// Sentences without an MMSI (GPS fixes) all go to the first worker.
+	mmsi, _ := payloadMMSI(assembled)
+	queue := p.queues[int(mmsi%uint32(len(p.queues)))]
+	job := pipelineJob{msg: msg, sourceKey: sourceKey}
+	if wait {
+		queue <- job
+		return
+	}
+	select {
+	case queue <- job:
+	default:
+		atomic.AddInt64(&p.dropped, 1)
+		if p.Debug {
+			log.Printf("[DEBUG] Pipeline queue full, dropped sentence from %s: %s", source, assembled)
+		}
+	}
+}
+
+// dedupeKey returns what two copies of a message must share to be
+// duplicates under the configured -dedupe-mode.
+func (p *Pipeline) dedupeKey(assembled string) string {
+	if p.DedupeMode == dedupeModePayload {
+		if key, ok := payloadDedupeKey(assembled); ok {
+			return key
+		}
+	}
+	return assembled
+}
+
+// worker decodes and merges queued sentences and hands them to the Sinks.
+func (p *Pipeline) worker(queue <-chan pipelineJob, codec *aisnmea.NMEACodec) {
+	for job := range queue {
+		p.process(job, codec)
+	}
+}
+
+func (p *Pipeline) process(job pipelineJob, codec *aisnmea.NMEACodec) {
+	msg, sourceKey := job.msg, job.sourceKey
+	source, assembled, receivedAt := msg.Source, msg.Assembled, msg.ReceivedAt
+	p.mu.RLock()
+	sinks := p.sinks
+	ownShip := p.ownShipSources[source]
+	p.mu.RUnlock()
+
+	for _, sink := range sinks {
+		sink.RawSentence(msg)
+	}
+
+	// GPS fixes from our own receiver carry the own-ship position; no other
+	// $ sentence is decoded.
+	if strings.HasPrefix(assembled, "$") {
+		if fix, ok := parseGPSFix(assembled); ok && ownShip {
+			updateOwnShipFix("", fix.Latitude, fix.Longitude, fix.Sog, fix.Cog, fix.Sentence, source, receivedAt)
+		} else {
+			recordSentenceError(sourceKey, reasonUnsupportedType)
+		}
+		return
+	}
+
+	decoded := p.decode(msg, sourceKey, codec)
+	if decoded == nil {
+		return
+	}
+	decoded.OwnShip = ownShip && isOwnShipSentence(assembled)
+	p.merge(decoded)
+
+	for _, sink := range sinks {
+		sink.Decoded(decoded)
+	}
+
+	// Diffing every vessel is expensive; skip it while an update is
+	// already waiting to be sent.
+	changeMutex.Lock()
+	pending := changeAvailable
+	changeMutex.Unlock()
+	if pending {
+		return
+	}
+	vesselDataMutex.Lock()
+	latestData := filterCompleteVesselData(vesselData)
+	previous := previousVesselData
+	vesselDataMutex.Unlock()
+	if !isDataChanged(latestData, previous) {
+		return
+	}
+	changeMutex.Lock()
+	changeAvailable = true
+	changeMutex.Unlock()
+}
+
+// decode turns a sentence into a DecodedMessage with cleaned fields, or
+// returns nil (after counting the reason) if it cannot be decoded.
+func (p *Pipeline) decode(msg *Sentence, sourceKey string, codec *aisnmea.NMEACodec) *DecodedMessage {
+	decoded, err := codec.ParseSentence(msg.Assembled)
+	if err != nil {
+		reason := decodeErrorReason(msg.Assembled)
+		recordSentenceError(sourceKey, reason)
+		log.Printf("Error decoding sentence from %s (%s): %v", msg.Source, reason, err)
+		return nil
+	}
+	if decoded == nil || decoded.Packet == nil {
+		recordSentenceError(sourceKey, reasonUnsupportedType)
+		return nil
+	}
+
+	newData := &Vessel{}
+	newData.apply(decoded.Packet)
+	var app *BinaryApplication
+	if id := decoded.Packet.GetHeader().MessageID; id == 6 || id == 8 {
+		app = decodeBinaryApplication(msg.Assembled)
+		newData.applyBinary(app)
+	}
+
+	aisMsg := AISMessage{
+		Type:      getMessageTypeName(decoded.Packet),
+		Data:      newData,
+		Timestamp: msg.ReceivedAt.Format(time.RFC3339Nano),
+		Station:   msg.Station,
+		Source:    msg.Source,
+	}
+	finalMsg, err := json.Marshal(aisMsg)
+	if err != nil {
+		log.Printf("Error marshaling AISMessage: %v", err)
+		return nil
+	}
+	if p.ShowDecodes {
+		log.Println("Decoded AIS Packet:", string(finalMsg))
+	}
+
+	return &DecodedMessage{
+		Sentence:    msg,
+		VesselID:    strconv.FormatUint(uint64(newData.UserID), 10),
+		Packet:      decoded.Packet,
+		Data:        newData,
+		Application: app,
+		JSON:        finalMsg,
+	}
+}
+
+// merge folds a decoded message into vesselData and sets msg.Vessel.
+func (p *Pipeline) merge(msg *DecodedMessage) {
+	vesselID := msg.VesselID
+	receivedAt := msg.ReceivedAt
+
+	msgType := getMessageTypeName(msg.Packet)
+
+	vesselDataMutex.Lock()
+	merged, ok := vesselData[vesselID]
+	if !ok {
+		merged = &Vessel{}
+		vesselData[vesselID] = merged
+	}
+	merged.apply(msg.Packet)
+	merged.applyBinary(msg.Application)
+	// A weather station may send nothing but FI 31; place it at its sensor.
+	if m := merged.MeteoHydro; m != nil && merged.Dynamic.Latitude == nil && m.Latitude != nil && m.Longitude != nil {
+		merged.Dynamic.Latitude, merged.Dynamic.Longitude = m.Latitude, m.Longitude
+	}
+	merged.classify(msgType)
+	merged.LastUpdated = receivedAt
+	merged.Source = msg.Source
+	if msg.OwnShip {
+		merged.OwnShip = true
+		if d := msg.Data.Dynamic; d.Latitude != nil && d.Longitude != nil {
+			updateOwnShipFix(vesselID, *d.Latitude, *d.Longitude, d.Sog, d.Cog, "AIVDO", msg.Source, receivedAt)
+		}
+	}
+	merged.addMessageType(msgType)
+	if sq := msg.Signal; sq != nil {
+		if sq.SignalPower != nil {
+			merged.SignalPower = sq.SignalPower
+		}
+		if sq.PPM != nil {
+			merged.PPM = sq.PPM
+		}
+		if sq.Channel != "" {
+			merged.Channel = sq.Channel
+		}
+		merged.SignalSource = msg.Source
+	}
+
+	// Count the message at its receive time (tag block or replay clock).
+	vesselMsgTimestampsMutex.Lock()
+	vesselMsgTimestamps[vesselID] = append(vesselMsgTimestamps[vesselID], receivedAt)
+	// Remove timestamps older than expire-after.
+	cutoff := receivedAt.Add(-p.ExpireAfter)
+	validTimestamps := vesselMsgTimestamps[vesselID][:0]
+	for _, t := range vesselMsgTimestamps[vesselID] {
+		if t.After(cutoff) {
+			validTimestamps = append(validTimestamps, t)
+		}
+	}
+	vesselMsgTimestamps[vesselID] = validTimestamps
+	vesselMsgTimestampsMutex.Unlock()
+
+	// Set rolling total for NumMessages.
+	merged.NumMessages = len(validTimestamps)
+	name := merged.Static.Name
+	vesselDataMutex.Unlock()
+	msg.Vessel = merged
+
+	if p.ExternalLookupURL != "" {
+		if strings.TrimSpace(name) == "" || name == "NO NAME" {
+			go externalLookupCall(vesselID, p.ExternalLookupURL, p.StateDir)
+		}
+	}
+}
+
+// payloadMMSI reads the MMSI (bits 8-37) from the payload of an !AIVDM or
+// !AIVDO sentence without fully decoding it.
+func payloadMMSI(sentence string) (uint32, bool) {
+	if !strings.HasPrefix(sentence, "!") {
+		return 0, false
+	}
+	fields := strings.SplitN(sentence, ",", 7)
+	if len(fields) < 6 || len(fields[5]) < 7 {
+		return 0, false
+	}
+	var bits uint64
+	for i := 0; i < 7; i++ {
+		v, ok := sixbitValue(fields[5][i])
+		if !ok {
+			return 0, false
+		}
+		bits = bits<<6 | uint64(v)
+	}
+	// 42 bits read; the MMSI is bits 8-37.
+	return uint32(bits>>4) & (1<<30 - 1), true
+}
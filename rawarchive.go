@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	rawArchivePrefix     = "raw-"
+	rawArchiveSuffix     = ".nmea"
+	rawArchiveTimeLayout = "2006010215"
+)
+
+// RawArchive records every received sentence, before dedupe, into hourly
+// files named raw-YYYYMMDDHH.nmea. Each line is
+//
+//	<receive time RFC 3339>\t<source>\t<sentence as received>
+//
+// which -replay reads back directly. Finished hours are gzip-compressed and
+// files older than the retention period are removed.
+type RawArchive struct {
+	mu        sync.Mutex
+	dir       string
+	retention time.Duration
+	hour      time.Time
+	file      *os.File
+	writer    *bufio.Writer
+}
+
+// rawArchive is nil unless -raw-archive-dir is set, and always with -replay.
+var rawArchive *RawArchive
+
+func newRawArchive(dir string, retention time.Duration) (*RawArchive, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	ra := &RawArchive{dir: dir, retention: retention}
+	// Compress anything left behind by a previous run, then apply retention.
+	ra.compressFinished("")
+	ra.prune()
+
+	go func() {
+		ticker := time.NewTicker(1 * time.Second)
+		defer ticker.Stop()
+		lastPrune := time.Now()
+		for range ticker.C {
+			ra.mu.Lock()
+			if ra.writer != nil {
+				if err := ra.writer.Flush(); err != nil {
+					log.Printf("Error flushing raw archive: %v", err)
+				}
+			}
+			// Finish the hour even if the feed has gone quiet.
+			if ra.file != nil && !clockNow().Truncate(time.Hour).Equal(ra.hour) {
+				ra.closeLocked()
+			}
+			ra.mu.Unlock()
+			if time.Since(lastPrune) >= time.Hour {
+				lastPrune = time.Now()
+				ra.prune()
+			}
+		}
+	}()
+	return ra, nil
+}
+
+// Record appends one received sentence, rotating to a new file on the hour.
+func (ra *RawArchive) Record(receivedAt time.Time, source, sentence string) {
+	if ra == nil {
+		return
+	}
+	receivedAt = receivedAt.UTC()
+	hour := receivedAt.Truncate(time.Hour)
+
+	ra.mu.Lock()
+	defer ra.mu.Unlock()
+	if ra.file == nil || !hour.Equal(ra.hour) {
+		if err := ra.rotateLocked(hour); err != nil {
+			log.Printf("Error rotating raw archive: %v", err)
+			return
+		}
+	}
+	fmt.Fprintf(ra.writer, "%s\t%s\t%s\n", receivedAt.Format(time.RFC3339Nano), source, strings.TrimSpace(sentence))
+}
+
+// closeLocked closes the current file and compresses it in the background.
+func (ra *RawArchive) closeLocked() {
+	ra.writer.Flush()
+	ra.file.Close()
+	current := ra.file.Name()
+	go func() {
+		ra.compressFinished(current)
+		ra.prune()
+	}()
+	ra.file = nil
+	ra.writer = nil
+}
+
+// rotateLocked closes the current file, if any, and opens the file for the
+// given hour.
+func (ra *RawArchive) rotateLocked(hour time.Time) error {
+	if ra.file != nil {
+		ra.closeLocked()
+	}
+	path := filepath.Join(ra.dir, rawArchivePrefix+hour.Format(rawArchiveTimeLayout)+rawArchiveSuffix)
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	ra.file = f
+	ra.writer = bufio.NewWriter(f)
+	ra.hour = hour
+	return nil
+}
+
+// compressFinished gzips uncompressed archive files. If only is non-empty,
+// just that file is compressed; otherwise every file except the current
+// hour's is.
+func (ra *RawArchive) compressFinished(only string) {
+	matches, err := filepath.Glob(filepath.Join(ra.dir, rawArchivePrefix+"*"+rawArchiveSuffix))
+	if err != nil {
+		log.Printf("Error listing raw archive files: %v", err)
+		return
+	}
+	current := filepath.Join(ra.dir, rawArchivePrefix+clockNow().Format(rawArchiveTimeLayout)+rawArchiveSuffix)
+	for _, path := range matches {
+		if only != "" && path != only {
+			continue
+		}
+		if only == "" && path == current {
+			continue
+		}
+		if err := gzipFile(path); err != nil {
+			log.Printf("Error compressing raw archive %s: %v", path, err)
+		}
+	}
+}
+
+// gzipFile writes path.gz and removes path once the copy is complete. It
+// refuses to replace an existing path.gz, leaving path in place.
+func gzipFile(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	gzPath := path + ".gz"
+	if _, err := os.Stat(gzPath); err == nil {
+		return fmt.Errorf("%s already exists", gzPath)
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	tmpPath := path + ".gz.tmp"
+	out, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	zw.Name = filepath.Base(path)
+	if _, err := io.Copy(zw, in); err != nil {
+		out.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		out.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, gzPath); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// prune removes archive files whose hour is older than the retention period.
+func (ra *RawArchive) prune() {
+	if ra.retention <= 0 {
+		return
+	}
+	entries, err := os.ReadDir(ra.dir)
+	if err != nil {
+		log.Printf("Error reading raw archive directory %s: %v", ra.dir, err)
+		return
+	}
+	cutoff := clockNow().Add(-ra.retention)
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, rawArchivePrefix) {
+			continue
+		}
+		stamp := strings.TrimPrefix(name, rawArchivePrefix)
+		if len(stamp) < len(rawArchiveTimeLayout) {
+			continue
+		}
+		hour, err := time.Parse(rawArchiveTimeLayout, stamp[:len(rawArchiveTimeLayout)])
+		if err != nil {
+			continue
+		}
+		// The file covers the whole hour, so keep it until its last minute expires.
+		if hour.Add(time.Hour).Before(cutoff) {
+			path := filepath.Join(ra.dir, name)
+			if err := os.Remove(path); err != nil {
+				log.Printf("Error removing expired raw archive %s: %v", path, err)
+			} else {
+				log.Printf("Removed expired raw archive %s", path)
+			}
+		}
+	}
+}
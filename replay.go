@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The replay clock stands in for wall time while -replay is active, so
+// vessel expiry, message counts and history follow the recorded timeline.
+var (
+	replayClockMutex sync.Mutex
+	replayClockTime  time.Time
+)
+
+// clockNow returns the wall clock, or the replayed time during a replay.
+func clockNow() time.Time {
+	replayClockMutex.Lock()
+	defer replayClockMutex.Unlock()
+	if replayClockTime.IsZero() {
+		return time.Now().UTC()
+	}
+	return replayClockTime
+}
+
+func setReplayClock(t time.Time) {
+	replayClockMutex.Lock()
+	replayClockTime = t.UTC()
+	replayClockMutex.Unlock()
+}
+
+// parseReplayLine splits a recorded line into its timestamp, source and
+// sentence. Lines may be bare sentences (optionally with a tag block) or be
+// prefixed by a timestamp column (RFC 3339 or Unix seconds) and an optional
+// source column, separated by whitespace or commas. The source column runs
+// to the next occurrence of the separator that followed the timestamp, so a
+// tab-separated label (as -raw-archive-dir writes) may contain anything else.
+func parseReplayLine(line string) (ts time.Time, source, sentence string, ok bool) {
+	isSeparator := func(r rune) bool {
+		return r == ' ' || r == '\t' || r == ','
+	}
+	isSentence := func(s string) bool {
+		return s != "" && strings.ContainsRune("!$\\", rune(s[0]))
+	}
+	rest := strings.TrimSpace(line)
+	if !isSentence(rest) {
+		end := strings.IndexFunc(rest, isSeparator)
+		if end < 0 {
+			return ts, "", "", false
+		}
+		ts = parseReplayTimestamp(rest[:end])
+		separator := rest[end]
+		rest = strings.TrimLeftFunc(rest[end:], isSeparator)
+		if !isSentence(rest) {
+			end = strings.IndexByte(rest, separator)
+			if end < 0 {
+				return ts, "", "", false
+			}
+			source = strings.TrimSpace(rest[:end])
+			rest = strings.TrimLeftFunc(rest[end:], isSeparator)
+		}
+		if !isSentence(rest) {
+			return ts, "", "", false
+		}
+	}
+	sentence = rest
+	if ts.IsZero() {
+		if tb, _, err := parseTagBlock(sentence); err == nil {
+			ts = tb.Timestamp
+		}
+	}
+	return ts, source, sentence, true
+}
+
+func parseReplayTimestamp(value string) time.Time {
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return t.UTC()
+	}
+	if secs, err := strconv.ParseFloat(value, 64); err == nil {
+		whole := int64(secs)
+		return time.Unix(whole, int64((secs-float64(whole))*1e9)).UTC()
+	}
+	return time.Time{}
+}
+
+// replaySource is a Source reading a -replay file. Run returns when the file
+// has been replayed.
+type replaySource struct {
+	path  string
+	speed float64
+}
+
+func (s *replaySource) Name() string { return "replay" }
+
+// WaitsForQueue keeps a replay from losing sentences when it outpaces the
+// workers, e.g. with -replay-speed 0.
+func (s *replaySource) WaitsForQueue() bool { return true }
+
+func (s *replaySource) Run(emit func(rawNmea, source string)) {
+	log.Printf("Replaying %s at speed %g", s.path, s.speed)
+	if err := runReplay(s.path, s.speed, emit); err != nil {
+		log.Printf("Error replaying %s: %v", s.path, err)
+	}
+}
+
+// runReplay feeds a recorded NMEA log through handle, preserving the original
+// spacing between messages divided by speed. A speed of 0 or less replays as
+// fast as possible. Lines without any timestamp reuse the previous one.
+// Gzip-compressed logs (such as finished -raw-archive-dir files) are accepted.
+func runReplay(path string, speed float64, handle func(rawNmea, source string)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		r = zr
+	}
+
+	var firstRecorded, lastRecorded time.Time
+	var wallStart time.Time
+	lines := 0
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		ts, source, sentence, ok := parseReplayLine(scanner.Text())
+		if !ok {
+			continue
+		}
+		if ts.IsZero() {
+			ts = lastRecorded
+		}
+		if !ts.IsZero() {
+			if firstRecorded.IsZero() {
+				firstRecorded = ts
+				wallStart = time.Now()
+			}
+			if speed > 0 {
+				offset := time.Duration(float64(ts.Sub(firstRecorded)) / speed)
+				if wait := time.Until(wallStart.Add(offset)); wait > 0 {
+					time.Sleep(wait)
+				}
+			}
+			setReplayClock(ts)
+			lastRecorded = ts
+		}
+		if source == "" {
+			source = "replay"
+		}
+		totalMessages.Add(1)
+		handle(sentence, source)
+		lines++
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	log.Printf("Replay of %s finished: %d sentences, recorded span %s to %s", path, lines,
+		firstRecorded.Format(time.RFC3339), lastRecorded.Format(time.RFC3339))
+	return nil
+}
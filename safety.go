@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	ais "github.com/BertoldVdb/go-ais"
+	"github.com/zishang520/socket.io/v2/socket"
+)
+
+// safetyRoom receives every safety-related message as it arrives.
+const safetyRoom = "safety_messages"
+
+// safetyLogMaxEntries bounds how many messages are kept in memory for
+// searching; the file keeps them until Prune expires them.
+const safetyLogMaxEntries = 10000
+
+// SafetyMessage is one type 12 (addressed) or type 14 (broadcast)
+// safety-related text message.
+type SafetyMessage struct {
+	ID            int64     `json:"id"`
+	ReceivedAt    time.Time `json:"received_at"`
+	MMSI          uint32    `json:"mmsi"`
+	Name          string    `json:"name,omitempty"`
+	DestinationID uint32    `json:"destination_mmsi,omitempty"` // type 12 only
+	Text          string    `json:"text"`
+	Source        string    `json:"source,omitempty"`
+	Station       string    `json:"station,omitempty"`
+}
+
+// SafetyLog keeps safety messages in memory for searching and appends them,
+// one JSON object per line, to safety_messages.jsonl in the state dir.
+type SafetyLog struct {
+	mu      sync.Mutex
+	path    string // "" with -no-state
+	entries []SafetyMessage
+	nextID  int64
+}
+
+// safetyLog is set up in main.
+var safetyLog *SafetyLog
+
+// openSafetyLog loads the messages already in path. An empty path keeps the
+// log in memory only.
+func openSafetyLog(path string) (*SafetyLog, error) {
+	sl := &SafetyLog{path: path, nextID: 1}
+	if path == "" {
+		return sl, nil
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return sl, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var m SafetyMessage
+		if err := json.Unmarshal(scanner.Bytes(), &m); err != nil {
+			continue
+		}
+		sl.appendLocked(m)
+		if m.ID >= sl.nextID {
+			sl.nextID = m.ID + 1
+		}
+	}
+	return sl, scanner.Err()
+}
+
+func (sl *SafetyLog) appendLocked(m SafetyMessage) {
+	sl.entries = append(sl.entries, m)
+	if len(sl.entries) > safetyLogMaxEntries {
+		sl.entries = append(sl.entries[:0], sl.entries[len(sl.entries)-safetyLogMaxEntries:]...)
+	}
+}
+
+// Add numbers the message, stores it and returns it as stored.
+func (sl *SafetyLog) Add(m SafetyMessage) SafetyMessage {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	m.ID = sl.nextID
+	sl.nextID++
+	sl.appendLocked(m)
+	if sl.path == "" {
+		return m
+	}
+	line, err := json.Marshal(m)
+	if err != nil {
+		log.Printf("Error marshaling safety message: %v", err)
+		return m
+	}
+	f, err := os.OpenFile(sl.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		log.Printf("Error opening safety message log %s: %v", sl.path, err)
+		return m
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		log.Printf("Error writing safety message log %s: %v", sl.path, err)
+	}
+	return m
+}
+
+// Prune drops messages received more than expireAfter ago, from memory and
+// from the file, which is rewritten with the rest or removed if none remain.
+func (sl *SafetyLog) Prune(expireAfter time.Duration) error {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	cutoff := time.Now().UTC().Add(-expireAfter)
+	kept := sl.entries[:0]
+	for _, m := range sl.entries {
+		if !m.ReceivedAt.Before(cutoff) {
+			kept = append(kept, m)
+		}
+	}
+	sl.entries = kept
+	if sl.path == "" {
+		return nil
+	}
+
+	in, err := os.Open(sl.path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer in.Close()
+	tmpPath := sl.path + ".tmp"
+	out, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	lines := 0
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		var m SafetyMessage
+		if err := json.Unmarshal(scanner.Bytes(), &m); err != nil || m.ReceivedAt.Before(cutoff) {
+			continue
+		}
+		if _, err := out.Write(append(scanner.Bytes(), '\n')); err != nil {
+			out.Close()
+			os.Remove(tmpPath)
+			return err
+		}
+		lines++
+	}
+	if err := scanner.Err(); err != nil {
+		out.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("reading %s: %w", sl.path, err)
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if lines == 0 {
+		os.Remove(tmpPath)
+		return os.Remove(sl.path)
+	}
+	return os.Rename(tmpPath, sl.path)
+}
+
+// SafetyQuery selects messages for Search. Zero fields match everything.
+type SafetyQuery struct {
+	MMSI  uint32    // sender or addressee
+	Text  string    // case-insensitive substring
+	Since time.Time // received at or after
+	Limit int       // at most this many, newest first
+}
+
+// Search returns the matching messages, newest first.
+func (sl *SafetyLog) Search(q SafetyQuery) []SafetyMessage {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	text := strings.ToUpper(q.Text)
+	out := make([]SafetyMessage, 0)
+	for i := len(sl.entries) - 1; i >= 0; i-- {
+		m := sl.entries[i]
+		if q.Limit > 0 && len(out) >= q.Limit {
+			break
+		}
+		if q.MMSI != 0 && m.MMSI != q.MMSI && m.DestinationID != q.MMSI {
+			continue
+		}
+		if text != "" && !strings.Contains(strings.ToUpper(m.Text), text) {
+			continue
+		}
+		if !q.Since.IsZero() && m.ReceivedAt.Before(q.Since) {
+			continue
+		}
+		out = append(out, m)
+	}
+	return out
+}
+
+// safetySink logs safety-related messages and pushes them to the
+// safety_messages room, whoever sent them.
+type safetySink struct {
+	server *socket.Server
+}
+
+func (s *safetySink) RawSentence(msg *Sentence) {}
+
+func (s *safetySink) Decoded(msg *DecodedMessage) {
+	m := SafetyMessage{
+		ReceivedAt: msg.ReceivedAt.UTC(),
+		MMSI:       msg.Data.UserID,
+		Source:     msg.Source,
+		Station:    msg.Station,
+	}
+	switch p := msg.Packet.(type) {
+	case *ais.AddressedSafetyMessage:
+		m.DestinationID = p.DestinationID
+		m.Text = strings.TrimSpace(p.Text)
+	case *ais.SafetyBroadcastMessage:
+		m.Text = strings.TrimSpace(p.Text)
+	default:
+		return
+	}
+	if msg.Vessel != nil {
+		m.Name = msg.Vessel.Static.Name
+	}
+	m = safetyLog.Add(m)
+
+	data, err := json.Marshal(m)
+	if err != nil {
+		log.Printf("Error marshaling safety message: %v", err)
+		return
+	}
+	if err := s.server.To(socket.Room(safetyRoom)).Emit("safety_message", string(data)); err != nil {
+		log.Printf("Error sending safety message to room %s: %v", safetyRoom, err)
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.bug.st/serial"
+)
+
+// serialPortConfig describes one receiver attached over a serial device.
+type serialPortConfig struct {
+	Label  string
+	Device string
+	Baud   int
+}
+
+// parseSerialSpec parses a -serial value of the form
+// name=/dev/ttyUSB0,baud=38400. The label may be omitted, in which case the
+// device's base name is used; baud defaults to defaultBaud.
+func parseSerialSpec(spec string, defaultBaud int) (serialPortConfig, error) {
+	cfg := serialPortConfig{Baud: defaultBaud}
+	fields := strings.Split(spec, ",")
+	first := strings.TrimSpace(fields[0])
+	if label, device, found := strings.Cut(first, "="); found {
+		cfg.Label = strings.TrimSpace(label)
+		cfg.Device = strings.TrimSpace(device)
+	} else {
+		cfg.Device = first
+	}
+	if cfg.Device == "" {
+		return cfg, fmt.Errorf("invalid -serial %q: missing device", spec)
+	}
+	if cfg.Label == "" {
+		cfg.Label = filepath.Base(cfg.Device)
+	}
+	for _, field := range fields[1:] {
+		key, value, found := strings.Cut(strings.TrimSpace(field), "=")
+		if !found {
+			return cfg, fmt.Errorf("invalid -serial option %q in %q", field, spec)
+		}
+		switch key {
+		case "baud":
+			baud, err := strconv.Atoi(value)
+			if err != nil || baud <= 0 {
+				return cfg, fmt.Errorf("invalid baud rate %q in %q", value, spec)
+			}
+			cfg.Baud = baud
+		default:
+			return cfg, fmt.Errorf("unknown -serial option %q in %q", key, spec)
+		}
+	}
+	return cfg, nil
+}
+
+const (
+	serialMinBackoff = 1 * time.Second
+	serialMaxBackoff = 30 * time.Second
+)
+
+// SerialPortMetrics is the per-port part of the metrics payload.
+type SerialPortMetrics struct {
+	Device         string  `json:"device"`
+	Connected      bool    `json:"connected"`
+	Reconnects     int     `json:"reconnects"`
+	LastError      string  `json:"last_error,omitempty"`
+	StateSince     string  `json:"state_since"`
+	MessagesPerSec float64 `json:"messages_per_sec"`
+	MessagesPerMin float64 `json:"messages_per_min"`
+}
+
+// serialPortState tracks one supervised serial port.
+type serialPortState struct {
+	counter    SlidingWindowCounter
+	device     string
+	connected  bool
+	everOpened bool
+	reconnects int
+	lastError  string
+	since      time.Time
+}
+
+var (
+	serialPortStatesMutex sync.Mutex
+	serialPortStates      = make(map[string]*serialPortState)
+)
+
+// serialPortStateLocked returns the state for label, creating it if needed.
+// serialPortStatesMutex must be held.
+func serialPortStateLocked(label string) *serialPortState {
+	state, ok := serialPortStates[label]
+	if !ok {
+		state = &serialPortState{since: time.Now().UTC()}
+		serialPortStates[label] = state
+	}
+	return state
+}
+
+// serialPortCounter returns the rate counter for the port with the given label.
+func serialPortCounter(label string) *SlidingWindowCounter {
+	serialPortStatesMutex.Lock()
+	defer serialPortStatesMutex.Unlock()
+	return &serialPortStateLocked(label).counter
+}
+
+// serialPortMetrics snapshots the status and message rates of every serial port.
+func serialPortMetrics() map[string]SerialPortMetrics {
+	serialPortStatesMutex.Lock()
+	defer serialPortStatesMutex.Unlock()
+	if len(serialPortStates) == 0 {
+		return nil
+	}
+	result := make(map[string]SerialPortMetrics, len(serialPortStates))
+	for label, state := range serialPortStates {
+		result[label] = SerialPortMetrics{
+			Device:         state.device,
+			Connected:      state.connected,
+			Reconnects:     state.reconnects,
+			LastError:      state.lastError,
+			StateSince:     state.since.Format(time.RFC3339),
+			MessagesPerSec: float64(state.counter.Count(1 * time.Second)),
+			MessagesPerMin: float64(state.counter.Count(1 * time.Minute)),
+		}
+	}
+	return result
+}
+
+// setSerialPortConnected records a successful open and logs the transition.
+func setSerialPortConnected(cfg serialPortConfig) {
+	serialPortStatesMutex.Lock()
+	defer serialPortStatesMutex.Unlock()
+	state := serialPortStateLocked(cfg.Label)
+	state.device = cfg.Device
+	if state.everOpened {
+		state.reconnects++
+	}
+	state.everOpened = true
+	state.connected = true
+	state.lastError = ""
+	state.since = time.Now().UTC()
+	log.Printf("Serial port %s: connected to %s at %d baud", cfg.Label, cfg.Device, cfg.Baud)
+}
+
+// setSerialPortDisconnected records a failure. It is logged when the port
+// goes down or the reason changes, so a missing device does not flood the log
+// while it is retried.
+func setSerialPortDisconnected(cfg serialPortConfig, err error) {
+	reason := "end of stream"
+	if err != nil {
+		reason = err.Error()
+	}
+	serialPortStatesMutex.Lock()
+	defer serialPortStatesMutex.Unlock()
+	state := serialPortStateLocked(cfg.Label)
+	state.device = cfg.Device
+	if state.connected || state.lastError != reason {
+		log.Printf("Serial port %s: disconnected from %s: %s (retrying)", cfg.Label, cfg.Device, reason)
+	}
+	if state.connected {
+		state.since = time.Now().UTC()
+	}
+	state.connected = false
+	state.lastError = reason
+}
+
+// serialSource is a Source for one serial port.
+type serialSource struct {
+	cfg   serialPortConfig
+	debug bool
+}
+
+func (s *serialSource) Name() string { return s.cfg.Label }
+
+// CarriesOwnShip reports that serial ports are attached to our own
+// transponder or GPS.
+func (s *serialSource) CarriesOwnShip() bool { return true }
+
+func (s *serialSource) Run(emit func(rawNmea, source string)) {
+	runSerialPort(s.cfg, func(line, source string) {
+		if s.debug {
+			log.Printf("[DEBUG] Received from Serial (%s) at %s: %s", source, time.Now().UTC().Format(time.RFC3339Nano), line)
+		}
+		if len(line) == 0 || (line[0] != '!' && line[0] != '$' && line[0] != '\\') {
+			return
+		}
+		serialCounter.AddEvent()
+		serialPortCounter(source).AddEvent()
+		totalMessages.Add(1)
+		emit(line, source)
+	})
+}
+
+// runSerialPort keeps a serial port open and passes every line read from it to
+// handle, labelled with the port's name. It never returns: read errors, EOF
+// (e.g. the USB receiver was unplugged) and open failures (the device node
+// does not exist yet) are retried with exponential backoff.
+func runSerialPort(cfg serialPortConfig, handle func(line, source string)) {
+	backoff := serialMinBackoff
+	for {
+		port, err := serial.Open(cfg.Device, &serial.Mode{BaudRate: cfg.Baud})
+		if err != nil {
+			setSerialPortDisconnected(cfg, err)
+			time.Sleep(backoff)
+			backoff = nextSerialBackoff(backoff)
+			continue
+		}
+		setSerialPortConnected(cfg)
+		backoff = serialMinBackoff
+
+		err = readSerialPort(cfg.Label, port, handle)
+		port.Close()
+		setSerialPortDisconnected(cfg, err)
+		time.Sleep(backoff)
+		backoff = nextSerialBackoff(backoff)
+	}
+}
+
+// readSerialPort passes every line read from port to handle until the port
+// returns EOF or an error, which is returned (nil for EOF).
+func readSerialPort(label string, port io.Reader, handle func(line, source string)) error {
+	scanner := bufio.NewScanner(port)
+	for scanner.Scan() {
+		handle(scanner.Text(), label)
+	}
+	return scanner.Err()
+}
+
+// nextSerialBackoff doubles the reopen delay up to serialMaxBackoff.
+func nextSerialBackoff(current time.Duration) time.Duration {
+	next := current * 2
+	if next > serialMaxBackoff {
+		next = serialMaxBackoff
+	}
+	return next
+}
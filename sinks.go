@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"time"
+
+	"github.com/zishang520/socket.io/v2/socket"
+)
+
+// socketIOSink emits decoded messages to the per-vessel ais_data rooms.
+type socketIOSink struct {
+	server *socket.Server
+}
+
+func (s *socketIOSink) RawSentence(msg *Sentence) {}
+
+func (s *socketIOSink) Decoded(msg *DecodedMessage) {
+	roomName := "ais_data/" + msg.VesselID
+	if err := s.server.To(socket.Room(roomName)).Emit("ais_data", string(msg.JSON)); err != nil {
+		log.Printf("Error sending decoded AIS data to room %s: %v", roomName, err)
+	}
+}
+
+// aggregatorSink forwards every sentence, as received, to the -aggregator
+// UDP endpoints, optionally stamped with our own tag block.
+type aggregatorSink struct {
+	conns     []*net.UDPConn
+	tagBlocks bool
+	stateDir  string
+	debug     bool
+}
+
+// line prepares a sentence for forwarding, stamping it with our station and
+// receive time if -aggregator-tag-blocks is set.
+func (s *aggregatorSink) line(sentence string, receivedAt time.Time) []byte {
+	if s.tagBlocks {
+		sentence = formatTagBlock(localStationID(s.stateDir), receivedAt) + sentence
+	}
+	return []byte(sentence)
+}
+
+func (s *aggregatorSink) RawSentence(msg *Sentence) {
+	for _, conn := range s.conns {
+		for _, part := range msg.Parts {
+			if _, err := conn.Write(s.line(part, msg.ReceivedAt)); err != nil {
+				if s.debug {
+					log.Printf("[DEBUG] Error sending raw NMEA sentence over UDP to aggregator: %v", err)
+				}
+			} else if s.debug {
+				log.Printf("[DEBUG] Forwarded raw NMEA sentence over UDP to aggregator: %s", part)
+			}
+		}
+	}
+}
+
+func (s *aggregatorSink) Decoded(msg *DecodedMessage) {}
+
+// decodeLogSink writes every decoded message under -log-all-decodes.
+type decodeLogSink struct {
+	dir string
+}
+
+func (s *decodeLogSink) RawSentence(msg *Sentence) {}
+
+func (s *decodeLogSink) Decoded(msg *DecodedMessage) {
+	logDecodedMessage(msg.Packet, s.dir)
+}
+
+// historySink appends vessel positions to the history files, filtering out
+// spurious jumps, and feeds the distance metrics.
+type historySink struct {
+	baseDir  string
+	noState  bool
+	stateDir string
+}
+
+func (s *historySink) RawSentence(msg *Sentence) {}
+
+func (s *historySink) Decoded(msg *DecodedMessage) {
+	vesselID := msg.VesselID
+	merged := msg.Vessel
+
+	// Append to vessel history only if lat/lon have changed by an acceptable amount.
+	if merged.Dynamic.Latitude == nil || merged.Dynamic.Longitude == nil {
+		return
+	}
+	lat, lon := *merged.Dynamic.Latitude, *merged.Dynamic.Longitude
+	vesselHistoryMutex.Lock()
+	defer vesselHistoryMutex.Unlock()
+	last, exists := vesselLastCoordinates[vesselID]
+	distance := 0.0
+	if exists {
+		distance = haversine(last.lat, last.lon, lat, lon)
+	}
+
+	// Check for spurious jump: if the distance is greater than 10 km.
+	if exists && distance > 10000.0 {
+		pendingVesselDataMutex.Lock()
+		defer pendingVesselDataMutex.Unlock()
+		pending, found := pendingVesselData[vesselID]
+		if !found {
+			// No pending update exists yet—store this spurious reading.
+			pendingVesselData[vesselID] = merged
+			return
+		}
+		// Compare new reading to the already pending one.
+		if pending.Dynamic.Latitude == nil || pending.Dynamic.Longitude == nil {
+			return
+		}
+		pLat, pLon := *pending.Dynamic.Latitude, *pending.Dynamic.Longitude
+		if haversine(pLat, pLon, lat, lon) > 10000.0 {
+			// The new update is still far from the pending one; update the pending update.
+			pendingVesselData[vesselID] = merged
+			return
+		}
+		// The new reading is close enough to the pending update.
+		// Commit the pending update to the vessel's current state.
+		vesselDataMutex.Lock()
+		vesselData[vesselID] = pending
+		vesselDataMutex.Unlock()
+		// Update the baseline coordinate.
+		vesselLastCoordinates[vesselID] = struct{ lat, lon float64 }{pLat, pLon}
+		s.append(vesselID, pLat, pLon, pending)
+		// Remove the pending update.
+		delete(pendingVesselData, vesselID)
+		return
+	}
+
+	// For very small movements (<10 m) only the baseline moves.
+	if !exists || distance >= 10.0 {
+		s.append(vesselID, lat, lon, merged)
+	}
+	// Update the baseline coordinate for future comparisons.
+	vesselLastCoordinates[vesselID] = struct{ lat, lon float64 }{lat, lon}
+	if msg.OwnShip {
+		// Our own range is always zero.
+		return
+	}
+	if receiverLat, receiverLon, err := loadReceiverCoordinates(s.stateDir); err == nil {
+		updateDistanceMetrics(lat, lon, receiverLat, receiverLon)
+	}
+}
+
+// append writes one history row for the vessel record.
+func (s *historySink) append(vesselID string, lat, lon float64, vessel *Vessel) {
+	if s.noState {
+		return
+	}
+	ts := vessel.LastUpdated.Format(time.RFC3339Nano)
+	var sogStr, cogStr, trueHeadingStr string
+	if sog := vessel.Dynamic.Sog; sog != nil {
+		sogStr = fmt.Sprintf("%.2f", *sog)
+	}
+	if cog := vessel.Dynamic.Cog; cog != nil {
+		cogStr = fmt.Sprintf("%.2f", *cog)
+	}
+	if th := vessel.Dynamic.TrueHeading; th != nil {
+		trueHeadingStr = fmt.Sprintf("%.2f", float64(*th))
+	}
+	if err := appendHistory(s.baseDir, vesselID, lat, lon, sogStr, cogStr, trueHeadingStr, ts); err != nil {
+		log.Printf("Error appending history for vessel %s: %v", vesselID, err)
+	}
+}
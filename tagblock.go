@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TagBlock holds the NMEA 4.10 tag block fields we care about, e.g.
+// \s:station1,c:1577836800*5A\!AIVDM,...
+type TagBlock struct {
+	Source    string    // s: source station ID
+	Timestamp time.Time // c: receive time, zero if absent
+	Group     string    // g: sentence grouping, if any
+}
+
+// parseTagBlock splits a leading tag block off line. Lines without a tag
+// block are returned unchanged with an empty TagBlock. An error is returned
+// if the tag block is unterminated or its checksum does not match.
+func parseTagBlock(line string) (TagBlock, string, error) {
+	var tb TagBlock
+	if !strings.HasPrefix(line, "\\") {
+		return tb, line, nil
+	}
+	end := strings.IndexByte(line[1:], '\\')
+	if end < 0 {
+		return tb, line, fmt.Errorf("unterminated tag block")
+	}
+	block := line[1 : end+1]
+	sentence := line[end+2:]
+
+	star := strings.LastIndexByte(block, '*')
+	if star < 0 {
+		return tb, sentence, fmt.Errorf("tag block missing checksum")
+	}
+	want, err := strconv.ParseUint(block[star+1:], 16, 8)
+	if err != nil {
+		return tb, sentence, fmt.Errorf("invalid tag block checksum %q", block[star+1:])
+	}
+	var sum byte
+	for i := 0; i < star; i++ {
+		sum ^= block[i]
+	}
+	if byte(want) != sum {
+		return tb, sentence, fmt.Errorf("tag block checksum mismatch: got %02X, want %02X", sum, want)
+	}
+
+	for _, field := range strings.Split(block[:star], ",") {
+		key, value, found := strings.Cut(field, ":")
+		if !found {
+			continue
+		}
+		switch key {
+		case "s":
+			tb.Source = value
+		case "c":
+			if secs, err := strconv.ParseInt(value, 10, 64); err == nil {
+				// Some sources send milliseconds rather than seconds.
+				if secs > 1e12 {
+					tb.Timestamp = time.UnixMilli(secs).UTC()
+				} else {
+					tb.Timestamp = time.Unix(secs, 0).UTC()
+				}
+			}
+		case "g":
+			tb.Group = value
+		}
+	}
+	return tb, sentence, nil
+}
+
+// receivedAt returns the tag block timestamp, or fallback if there is none.
+func (tb TagBlock) receivedAt(fallback time.Time) time.Time {
+	if tb.Timestamp.IsZero() {
+		return fallback
+	}
+	return tb.Timestamp
+}
+
+// sourceKey qualifies a transport-level source (e.g. a UDP peer address) with
+// the tag block station ID, so aggregated feeds keep stations apart.
+func (tb TagBlock) sourceKey(source string) string {
+	if tb.Source == "" {
+		return source
+	}
+	return source + "/" + tb.Source
+}
+
+// formatTagBlock builds a tag block stamping a sentence with the station that
+// heard it and when, e.g. \s:harbour,c:1577836800*hh\.
+func formatTagBlock(station string, receivedAt time.Time) string {
+	var fields []string
+	if station = sanitizeTagValue(station); station != "" {
+		fields = append(fields, "s:"+station)
+	}
+	fields = append(fields, "c:"+strconv.FormatInt(receivedAt.Unix(), 10))
+	body := strings.Join(fields, ",")
+	var sum byte
+	for i := 0; i < len(body); i++ {
+		sum ^= body[i]
+	}
+	return fmt.Sprintf("\\%s*%02X\\", body, sum)
+}
+
+// sanitizeTagValue removes characters that would break tag block framing.
+func sanitizeTagValue(value string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r == ',' || r == '*' || r == '\\' || r == '!' || r == '$':
+			return -1
+		case r < 0x20 || r > 0x7e:
+			return -1
+		case r == ' ':
+			return '_'
+		}
+		return r
+	}, strings.TrimSpace(value))
+}
+
+// stationIDCacheTTL bounds how long a renamed station keeps the old s: value.
+const stationIDCacheTTL = time.Minute
+
+var (
+	stationIDMutex  sync.Mutex
+	stationIDValue  string
+	stationIDLoaded time.Time
+)
+
+// localStationID returns the name from myinfo.json, falling back to its UUID.
+// The value is cached briefly since it is needed for every forwarded sentence.
+func localStationID(stateDir string) string {
+	stationIDMutex.Lock()
+	defer stationIDMutex.Unlock()
+	if !stationIDLoaded.IsZero() && time.Since(stationIDLoaded) < stationIDCacheTTL {
+		return stationIDValue
+	}
+	stationIDLoaded = time.Now()
+
+	data, err := os.ReadFile(filepath.Join(stateDir, "myinfo.json"))
+	if err != nil {
+		return stationIDValue
+	}
+	var myinfo map[string]string
+	if err := json.Unmarshal(data, &myinfo); err != nil {
+		return stationIDValue
+	}
+	if name := sanitizeTagValue(myinfo["name"]); name != "" {
+		stationIDValue = name
+	} else {
+		stationIDValue = strings.TrimSpace(myinfo["uuid"])
+	}
+	return stationIDValue
+}
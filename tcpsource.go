@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bufio"
+	"log"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	tcpSourceDialTimeout = 10 * time.Second
+	// A feed that stays silent this long is treated as dead and redialled.
+	tcpSourceIdleTimeout = 5 * time.Minute
+	tcpSourceMinBackoff  = 1 * time.Second
+	tcpSourceMaxBackoff  = 2 * time.Minute
+)
+
+// tcpSource is a Source for one -tcp-source feed.
+type tcpSource struct {
+	addr  string
+	debug bool
+}
+
+func (s *tcpSource) Name() string { return s.addr }
+
+func (s *tcpSource) Run(emit func(rawNmea, source string)) {
+	runTCPSource(s.addr, s.debug, emit)
+}
+
+// runTCPSource connects out to a remote NMEA feed (AIS-catcher, rtl-ais, shore
+// station concentrators, ...) and passes every AIVDM/AIVDO line to handle.
+// It never returns: dropped connections are redialled with exponential backoff.
+func runTCPSource(addr string, debug bool, handle func(rawNmea, source string)) {
+	backoff := tcpSourceMinBackoff
+	for {
+		conn, err := net.DialTimeout("tcp", addr, tcpSourceDialTimeout)
+		if err != nil {
+			log.Printf("TCP source %s: connect failed: %v (retrying in %s)", addr, err, backoff)
+			time.Sleep(backoff)
+			backoff = nextTCPSourceBackoff(backoff)
+			continue
+		}
+		log.Printf("TCP source %s: connected", addr)
+		backoff = tcpSourceMinBackoff
+
+		conn.SetReadDeadline(time.Now().Add(tcpSourceIdleTimeout))
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			conn.SetReadDeadline(time.Now().Add(tcpSourceIdleTimeout))
+			line := strings.TrimSpace(scanner.Text())
+			if debug {
+				log.Printf("[DEBUG] Received from TCP (%s) at %s: %s", addr, time.Now().UTC().Format(time.RFC3339Nano), line)
+			}
+			if len(line) == 0 || (line[0] != '!' && line[0] != '$' && line[0] != '\\') {
+				continue
+			}
+			tcpCounter.AddEvent()
+			totalMessages.Add(1)
+			handle(line, addr)
+		}
+		if err := scanner.Err(); err != nil {
+			log.Printf("TCP source %s: read error: %v", addr, err)
+		}
+		conn.Close()
+		log.Printf("TCP source %s: disconnected, reconnecting in %s", addr, backoff)
+		time.Sleep(backoff)
+		backoff = nextTCPSourceBackoff(backoff)
+	}
+}
+
+// nextTCPSourceBackoff doubles the reconnect delay up to tcpSourceMaxBackoff.
+func nextTCPSourceBackoff(current time.Duration) time.Duration {
+	next := current * 2
+	if next > tcpSourceMaxBackoff {
+		next = tcpSourceMaxBackoff
+	}
+	return next
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"log"
+	"net"
+	"time"
+)
+
+// udpSource is the Source for the -udp-listen-port listener. Datagrams may
+// carry several sentences; each is emitted with the sender's address.
+type udpSource struct {
+	conn  net.PacketConn
+	debug bool
+}
+
+func (s *udpSource) Name() string { return "udp" }
+
+func (s *udpSource) Run(emit func(rawNmea, source string)) {
+	buf := make([]byte, maxUDPDatagramSize)
+	for {
+		n, addr, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			log.Printf("Error reading UDP message: %v", err)
+			continue
+		}
+		source := addr.String()
+		if s.debug {
+			log.Printf("[DEBUG] Received from UDP (%s) at %s: %s", source, time.Now().UTC().Format(time.RFC3339Nano), string(buf[:n]))
+		}
+		// A datagram that fills the buffer was cut short by the kernel.
+		lines, truncated, malformed := splitDatagram(buf[:n], n == len(buf))
+		udpTruncatedLines.Add(int64(truncated))
+		udpMalformedLines.Add(int64(malformed))
+		for _, rawNmea := range lines {
+			udpCounter.AddEvent()
+			totalMessages.Add(1)
+			emit(rawNmea, source)
+		}
+	}
+}
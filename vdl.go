@@ -0,0 +1,437 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	ais "github.com/BertoldVdb/go-ais"
+)
+
+// VDL (VHF data link) management messages are how base stations steer the
+// slots and channels other stations use: interrogations (type 15), assigned
+// mode commands (16), slot reservations (20), channel management (22) and
+// group assignments (23). go-ais decodes them; they are kept in vdlState,
+// served on /vdl.
+
+const (
+	// vdlRetention is how long channel management and group assignments are
+	// kept after they were last heard. Base stations repeat them every few
+	// minutes while they apply.
+	vdlRetention = time.Hour
+	// assignedModeTimeout is the longest an assigned mode command lasts
+	// (ITU-R M.1371: 4 to 8 minutes).
+	assignedModeTimeout = 8 * time.Minute
+	// vdlInterrogationWindow and vdlMaxInterrogations bound the
+	// interrogations kept.
+	vdlInterrogationWindow = 10 * time.Minute
+	vdlMaxInterrogations   = 200
+)
+
+// VDLHeard is the sender of a VDL management message and where it was heard.
+type VDLHeard struct {
+	StationID  uint32 // MMSI of the sending station
+	ReceivedAt time.Time
+	Source     string
+	Station    string `json:",omitempty"`
+	// arrivedAt is our arrival time, which expiry is measured from so a
+	// receiver with a skewed clock does not shift it.
+	arrivedAt time.Time
+}
+
+// VDLArea is a rectangle given by its north-east and south-west corners.
+// Corners the station marks as not available are nil.
+type VDLArea struct {
+	NorthEastLatitude  *float64 `json:",omitempty"`
+	NorthEastLongitude *float64 `json:",omitempty"`
+	SouthWestLatitude  *float64 `json:",omitempty"`
+	SouthWestLongitude *float64 `json:",omitempty"`
+}
+
+// VDLInterrogation is one request of a type 15 message: DestinationID is
+// asked to send a MessageID report.
+type VDLInterrogation struct {
+	VDLHeard
+	DestinationID uint32
+	MessageID     uint8
+	SlotOffset    uint16 // 0 lets the interrogated station pick the slot
+}
+
+// VDLAssignedMode is a type 16 command putting DestinationID into assigned
+// mode, either at fixed slots or at a reporting rate.
+type VDLAssignedMode struct {
+	VDLHeard
+	DestinationID   uint32
+	SlotOffset      uint16 `json:",omitempty"` // first slot, if Increment is set
+	Increment       uint16 `json:",omitempty"` // slots between reports
+	ReportsPer10Min uint16 `json:",omitempty"` // if Increment is 0
+	ExpiresAt       time.Time
+}
+
+// VDLReservation is one block of slots reserved by a type 20 message.
+type VDLReservation struct {
+	Offset    uint16 // first slot, counted from the slot the message was sent in
+	Slots     uint8  // consecutive slots
+	Timeout   uint8  // minutes
+	Increment uint16 // slots between repeats of the block, 0 for once a frame
+	ExpiresAt time.Time
+}
+
+// VDLSlotReservations is the latest type 20 message of a base station.
+type VDLSlotReservations struct {
+	VDLHeard
+	Reservations []VDLReservation
+}
+
+// VDLChannelManagement is a type 22 message: the channels and power to use
+// either within Area (broadcast) or by DestinationIDs (addressed).
+type VDLChannelManagement struct {
+	VDLHeard
+	ChannelA         uint16   // ITU-R M.1084 channel number, 2087 by default
+	ChannelB         uint16   // 2088 by default
+	TxRxMode         uint8    // 0 Tx/Rx on A and B, 1 Tx A only, 2 Tx B only, 3 Rx only
+	LowPower         bool     // 1 W instead of 12.5 W
+	Area             *VDLArea `json:",omitempty"`
+	DestinationIDs   []uint32 `json:",omitempty"`
+	TransitionalZone uint8    // nautical miles
+}
+
+// VDLGroupAssignment is a type 23 message: stations of StationType and
+// ShipType within Area are assigned a reporting interval.
+type VDLGroupAssignment struct {
+	VDLHeard
+	Area                     VDLArea
+	StationType              uint8   // 0 all mobile stations, 1 Class A, 2 all Class B, 3 SAR aircraft, 5 Class B SO, 6 Class B CS, 7 inland, 10 base station coverage area
+	ShipType                 uint8   // 0 all types
+	TxRxMode                 uint8   // as VDLChannelManagement, 3 is reserved
+	ReportingInterval        uint8   // ITU-R M.1371 code
+	ReportingIntervalSeconds *uint16 `json:",omitempty"` // for codes with a fixed interval
+	QuietTime                uint8   // minutes, 0 for none
+}
+
+// groupReportingIntervals maps type 23 reporting interval codes to seconds.
+// 0 is autonomous mode, 9 and 10 the next shorter or longer interval.
+var groupReportingIntervals = map[uint8]uint16{
+	1: 600, 2: 360, 3: 180, 4: 60, 5: 30, 6: 15, 7: 10, 8: 5, 11: 2,
+}
+
+// VDLSnapshot is the VDL management state served on /vdl, newest first.
+type VDLSnapshot struct {
+	SlotReservations  []VDLSlotReservations
+	ChannelManagement []VDLChannelManagement
+	AssignedModes     []VDLAssignedMode
+	GroupAssignments  []VDLGroupAssignment
+	Interrogations    []VDLInterrogation
+}
+
+// VDLState holds what is currently in force, keyed so that a repeated
+// message replaces the one it repeats.
+type VDLState struct {
+	mu               sync.Mutex
+	reservations     map[uint32]*VDLSlotReservations  // by base station
+	channels         map[string]*VDLChannelManagement // by station and area or addressees
+	assignedModes    map[uint32]*VDLAssignedMode      // by destination
+	groupAssignments map[string]*VDLGroupAssignment   // by station, area and group
+	interrogations   []VDLInterrogation
+}
+
+func newVDLState() *VDLState {
+	return &VDLState{
+		reservations:     make(map[uint32]*VDLSlotReservations),
+		channels:         make(map[string]*VDLChannelManagement),
+		assignedModes:    make(map[uint32]*VDLAssignedMode),
+		groupAssignments: make(map[string]*VDLGroupAssignment),
+	}
+}
+
+var vdlState = newVDLState()
+
+// Record updates the state if msg is a VDL management message. Anything
+// else is ignored.
+func (s *VDLState) Record(msg *DecodedMessage) {
+	heard := VDLHeard{
+		StationID:  msg.Packet.GetHeader().UserID,
+		ReceivedAt: msg.ReceivedAt,
+		Source:     msg.Source,
+		Station:    msg.Station,
+		arrivedAt:  msg.ArrivedAt,
+	}
+
+	switch p := msg.Packet.(type) {
+	case *ais.Interrogation:
+		interrogations := interrogationRequests(p, heard)
+		s.mu.Lock()
+		s.interrogations = append(s.interrogations, interrogations...)
+		if len(s.interrogations) > vdlMaxInterrogations {
+			s.interrogations = append(s.interrogations[:0], s.interrogations[len(s.interrogations)-vdlMaxInterrogations:]...)
+		}
+		s.mu.Unlock()
+	case *ais.AssignedModeCommand:
+		commands := assignedModeCommands(p, heard)
+		s.mu.Lock()
+		for i := range commands {
+			s.assignedModes[commands[i].DestinationID] = &commands[i]
+		}
+		s.mu.Unlock()
+	case *ais.DataLinkManagementMessage:
+		reservations := slotReservations(p, heard)
+		s.mu.Lock()
+		s.reservations[heard.StationID] = reservations
+		s.mu.Unlock()
+	case *ais.ChannelManagement:
+		channels := channelManagement(p, heard)
+		key := fmt.Sprint(heard.StationID, channels.DestinationIDs)
+		if channels.Area != nil {
+			key = fmt.Sprint(heard.StationID, vdlAreaKey(channels.Area))
+		}
+		s.mu.Lock()
+		s.channels[key] = channels
+		s.mu.Unlock()
+	case *ais.GroupAssignmentCommand:
+		group := groupAssignment(p, heard)
+		key := fmt.Sprint(heard.StationID, vdlAreaKey(&group.Area), group.StationType, group.ShipType)
+		s.mu.Lock()
+		s.groupAssignments[key] = group
+		s.mu.Unlock()
+	}
+}
+
+// Snapshot drops what has expired and returns the rest, newest first. A
+// non-zero mmsi keeps only messages sent by or addressed to that station.
+func (s *VDLState) Snapshot(now time.Time, mmsi uint32) VDLSnapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	matches := func(heard VDLHeard, destinations ...uint32) bool {
+		if mmsi == 0 || heard.StationID == mmsi {
+			return true
+		}
+		for _, d := range destinations {
+			if d == mmsi {
+				return true
+			}
+		}
+		return false
+	}
+
+	snap := VDLSnapshot{
+		SlotReservations:  make([]VDLSlotReservations, 0),
+		ChannelManagement: make([]VDLChannelManagement, 0),
+		AssignedModes:     make([]VDLAssignedMode, 0),
+		GroupAssignments:  make([]VDLGroupAssignment, 0),
+		Interrogations:    make([]VDLInterrogation, 0),
+	}
+	for station, r := range s.reservations {
+		active := r.Reservations[:0]
+		for _, res := range r.Reservations {
+			if res.ExpiresAt.After(now) {
+				active = append(active, res)
+			}
+		}
+		r.Reservations = active
+		if len(active) == 0 {
+			delete(s.reservations, station)
+			continue
+		}
+		if matches(r.VDLHeard) {
+			r := *r
+			r.Reservations = append([]VDLReservation(nil), active...)
+			snap.SlotReservations = append(snap.SlotReservations, r)
+		}
+	}
+	for key, c := range s.channels {
+		if now.Sub(c.arrivedAt) > vdlRetention {
+			delete(s.channels, key)
+		} else if matches(c.VDLHeard, c.DestinationIDs...) {
+			snap.ChannelManagement = append(snap.ChannelManagement, *c)
+		}
+	}
+	for destination, a := range s.assignedModes {
+		if !a.ExpiresAt.After(now) {
+			delete(s.assignedModes, destination)
+		} else if matches(a.VDLHeard, a.DestinationID) {
+			snap.AssignedModes = append(snap.AssignedModes, *a)
+		}
+	}
+	for key, g := range s.groupAssignments {
+		if now.Sub(g.arrivedAt) > vdlRetention {
+			delete(s.groupAssignments, key)
+		} else if matches(g.VDLHeard) {
+			snap.GroupAssignments = append(snap.GroupAssignments, *g)
+		}
+	}
+	i := 0
+	for i < len(s.interrogations) && now.Sub(s.interrogations[i].arrivedAt) > vdlInterrogationWindow {
+		i++
+	}
+	s.interrogations = s.interrogations[i:]
+	for j := len(s.interrogations) - 1; j >= 0; j-- {
+		if q := s.interrogations[j]; matches(q.VDLHeard, q.DestinationID) {
+			snap.Interrogations = append(snap.Interrogations, q)
+		}
+	}
+
+	sort.Slice(snap.SlotReservations, func(i, j int) bool {
+		return snap.SlotReservations[i].ReceivedAt.After(snap.SlotReservations[j].ReceivedAt)
+	})
+	sort.Slice(snap.ChannelManagement, func(i, j int) bool {
+		return snap.ChannelManagement[i].ReceivedAt.After(snap.ChannelManagement[j].ReceivedAt)
+	})
+	sort.Slice(snap.AssignedModes, func(i, j int) bool {
+		return snap.AssignedModes[i].ReceivedAt.After(snap.AssignedModes[j].ReceivedAt)
+	})
+	sort.Slice(snap.GroupAssignments, func(i, j int) bool {
+		return snap.GroupAssignments[i].ReceivedAt.After(snap.GroupAssignments[j].ReceivedAt)
+	})
+	return snap
+}
+
+// interrogationRequests lists the one to three requests of a type 15
+// message.
+func interrogationRequests(p *ais.Interrogation, heard VDLHeard) []VDLInterrogation {
+	out := []VDLInterrogation{{
+		VDLHeard:      heard,
+		DestinationID: p.Station1Msg1.StationID,
+		MessageID:     p.Station1Msg1.MessageID,
+		SlotOffset:    p.Station1Msg1.SlotOffset,
+	}}
+	// A second message from the same station.
+	if p.Station1Msg2.Valid && p.Station1Msg2.MessageID != 0 {
+		out = append(out, VDLInterrogation{
+			VDLHeard:      heard,
+			DestinationID: p.Station1Msg1.StationID,
+			MessageID:     p.Station1Msg2.MessageID,
+			SlotOffset:    p.Station1Msg2.SlotOffset,
+		})
+	}
+	// A message from a second station.
+	if p.Station2.Valid && p.Station2.StationID != 0 {
+		out = append(out, VDLInterrogation{
+			VDLHeard:      heard,
+			DestinationID: p.Station2.StationID,
+			MessageID:     p.Station2.MessageID,
+			SlotOffset:    p.Station2.SlotOffset,
+		})
+	}
+	return out
+}
+
+// assignedModeCommands lists the one or two commands of a type 16 message.
+func assignedModeCommands(p *ais.AssignedModeCommand, heard VDLHeard) []VDLAssignedMode {
+	var out []VDLAssignedMode
+	for i, c := range []ais.AssignedModeCommandData{p.CommandA, p.CommandB} {
+		if (i > 0 && !c.Valid) || c.DestinationID == 0 {
+			continue
+		}
+		a := VDLAssignedMode{
+			VDLHeard:      heard,
+			DestinationID: c.DestinationID,
+			ExpiresAt:     heard.arrivedAt.Add(assignedModeTimeout),
+		}
+		if c.Increment == 0 {
+			a.ReportsPer10Min = c.Offset
+		} else {
+			a.SlotOffset, a.Increment = c.Offset, c.Increment
+		}
+		out = append(out, a)
+	}
+	return out
+}
+
+// slotReservations lists the one to four reservation blocks of a type 20
+// message. Blocks of zero slots are unused.
+func slotReservations(p *ais.DataLinkManagementMessage, heard VDLHeard) *VDLSlotReservations {
+	r := &VDLSlotReservations{VDLHeard: heard}
+	for i, d := range p.Data {
+		if (i > 0 && !d.Valid) || d.NumberOfSlots == 0 {
+			continue
+		}
+		r.Reservations = append(r.Reservations, VDLReservation{
+			Offset:    d.Offset,
+			Slots:     d.NumberOfSlots,
+			Timeout:   d.TimeOut,
+			Increment: d.Increment,
+			ExpiresAt: heard.arrivedAt.Add(time.Duration(d.TimeOut) * time.Minute),
+		})
+	}
+	return r
+}
+
+// channelManagement converts a type 22 message, which carries either an
+// area or two addressees.
+func channelManagement(p *ais.ChannelManagement, heard VDLHeard) *VDLChannelManagement {
+	c := &VDLChannelManagement{
+		VDLHeard:         heard,
+		ChannelA:         p.ChannelA,
+		ChannelB:         p.ChannelB,
+		TxRxMode:         p.TxRxMode,
+		LowPower:         p.LowPower,
+		TransitionalZone: p.TransitionalZoneSize + 1,
+	}
+	if p.IsAddressed {
+		for _, id := range []uint32{p.Unicast.AddressStation1, p.Unicast.AddressStation2} {
+			if id != 0 {
+				c.DestinationIDs = append(c.DestinationIDs, id)
+			}
+		}
+	} else {
+		c.Area = newVDLArea(p.Area.Latitude1, p.Area.Longitude1, p.Area.Latitude2, p.Area.Longitude2)
+	}
+	return c
+}
+
+// groupAssignment converts a type 23 message.
+func groupAssignment(p *ais.GroupAssignmentCommand, heard VDLHeard) *VDLGroupAssignment {
+	g := &VDLGroupAssignment{
+		VDLHeard:          heard,
+		Area:              *newVDLArea(p.Latitude1, p.Longitude1, p.Latitude2, p.Longitude2),
+		StationType:       p.StationType,
+		ShipType:          p.ShipType,
+		TxRxMode:          p.TxRxMode,
+		ReportingInterval: p.ReportingInterval,
+		QuietTime:         p.QuietTime,
+	}
+	if seconds, ok := groupReportingIntervals[g.ReportingInterval]; ok {
+		g.ReportingIntervalSeconds = &seconds
+	}
+	return g
+}
+
+// newVDLArea builds an area from its north-east and south-west corners.
+func newVDLArea(neLat, neLon, swLat, swLon ais.FieldLatLonCoarse) *VDLArea {
+	return &VDLArea{
+		NorthEastLatitude:  vdlCoordinate(float64(neLat), 90),
+		NorthEastLongitude: vdlCoordinate(float64(neLon), 180),
+		SouthWestLatitude:  vdlCoordinate(float64(swLat), 90),
+		SouthWestLongitude: vdlCoordinate(float64(swLon), 180),
+	}
+}
+
+// vdlCoordinate returns deg, or nil if out of range (181° and 91° mean not
+// available).
+func vdlCoordinate(deg float64, limit float64) *float64 {
+	if deg < -limit || deg > limit {
+		return nil
+	}
+	return &deg
+}
+
+// vdlAreaKey identifies an area for replacing repeated messages.
+func vdlAreaKey(a *VDLArea) string {
+	f := func(v *float64) string {
+		if v == nil {
+			return "-"
+		}
+		return fmt.Sprintf("%.4f", *v)
+	}
+	return f(a.NorthEastLatitude) + "," + f(a.NorthEastLongitude) + "," + f(a.SouthWestLatitude) + "," + f(a.SouthWestLongitude)
+}
+
+// vdlSink feeds VDL management messages into vdlState.
+type vdlSink struct{}
+
+func (s *vdlSink) RawSentence(msg *Sentence) {}
+
+func (s *vdlSink) Decoded(msg *DecodedMessage) {
+	vdlState.Record(msg)
+}
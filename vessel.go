@@ -0,0 +1,508 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	ais "github.com/BertoldVdb/go-ais"
+)
+
+// VesselStatic is what a station reports about itself: identity, type and
+// build. It changes rarely.
+type VesselStatic struct {
+	Name       string              `json:",omitempty"`
+	CallSign   string              `json:",omitempty"`
+	ImageURL   string              `json:",omitempty"` // from -external-lookup
+	ImoNumber  *uint32             `json:",omitempty"`
+	Type       *uint8              `json:",omitempty"` // ship type, or aid type for an AtoN
+	Dimension  *ais.FieldDimension `json:",omitempty"`
+	FixType    *uint8              `json:",omitempty"`
+	AisVersion *uint8              `json:",omitempty"`
+
+	// Class B unit capabilities (type 18).
+	ClassBUnit    *bool `json:",omitempty"`
+	ClassBDisplay *bool `json:",omitempty"`
+	ClassBDsc     *bool `json:",omitempty"`
+	ClassBBand    *bool `json:",omitempty"`
+	ClassBMsg22   *bool `json:",omitempty"`
+
+	// Class B static data (type 24 part B). An auxiliary craft (MMSI
+	// 98MIDxxxx) reports its mothership's MMSI instead of its dimensions.
+	VendorID       string  `json:",omitempty"`
+	UnitModelCode  *uint8  `json:",omitempty"`
+	SerialNumber   *uint32 `json:",omitempty"`
+	MothershipMMSI *uint32 `json:",omitempty"`
+
+	// Aid to navigation status (type 21).
+	AtoN        *uint8 `json:",omitempty"`
+	VirtualAtoN *bool  `json:",omitempty"`
+
+	// Binary application messages (types 6 and 8).
+	ExtendedStatic *ExtendedShipStatic `json:",omitempty"` // DAC 1 FI 24
+	InlandStatic   *InlandShipStatic   `json:",omitempty"` // DAC 200 FI 10, with the ENI
+}
+
+// VesselVoyage is the current voyage as entered by the crew (type 5).
+type VesselVoyage struct {
+	Destination          string        `json:",omitempty"`
+	Eta                  *ais.FieldETA `json:",omitempty"`
+	MaximumStaticDraught *float64      `json:",omitempty"`
+	Dte                  *bool         `json:",omitempty"`
+}
+
+// BaseStationUTC is the clock a base station broadcasts (type 4).
+type BaseStationUTC struct {
+	UtcYear   uint16
+	UtcMonth  uint8
+	UtcDay    uint8
+	UtcHour   uint8
+	UtcMinute uint8
+	UtcSecond uint8
+}
+
+// VesselDynamic is the position and motion from the latest reports.
+type VesselDynamic struct {
+	Latitude                  *float64 `json:",omitempty"`
+	Longitude                 *float64 `json:",omitempty"`
+	Sog                       *float64 `json:",omitempty"`
+	Cog                       *float64 `json:",omitempty"`
+	TrueHeading               *uint16  `json:",omitempty"`
+	RateOfTurn                *int16   `json:",omitempty"`
+	NavigationalStatus        *uint8   `json:",omitempty"`
+	PositionAccuracy          *bool    `json:",omitempty"`
+	Timestamp                 *uint8   `json:",omitempty"`
+	SpecialManoeuvreIndicator *uint8   `json:",omitempty"`
+	Raim                      *bool    `json:",omitempty"`
+	AssignedMode              *bool    `json:",omitempty"`
+	CommunicationState        *uint32  `json:",omitempty"`
+	CommunicationStateIsItdma *bool    `json:",omitempty"`
+	Altitude                  *uint16  `json:",omitempty"` // SAR aircraft
+	AltFromBaro               *bool    `json:",omitempty"`
+	OffPosition               *bool    `json:",omitempty"` // AtoN
+	PositionLatency           *bool    `json:",omitempty"` // long range broadcast
+	LongRangeEnable           *bool    `json:",omitempty"` // base station
+	*BaseStationUTC
+}
+
+// Vessel is the merged state of one station, keyed by MMSI in vesselData.
+// A nil pointer field means the value has not been heard yet.
+type Vessel struct {
+	UserID          uint32
+	MID             int
+	MessageID       uint8 // type of the last message merged
+	RepeatIndicator uint8
+	AISClass        string
+
+	Static  VesselStatic
+	Voyage  VesselVoyage
+	Dynamic VesselDynamic
+
+	MeteoHydro *MeteoHydro // latest DAC 1 FI 31 weather report
+	AreaNotice *AreaNotice // latest DAC 1 FI 22 area notice
+
+	LastUpdated  time.Time
+	Source       string // input that heard the last message
+	OwnShip      bool
+	MessageTypes []string
+	NumMessages  int // messages within -expire-after
+	SignalPower  *float64
+	PPM          *float64
+	Channel      string
+	SignalSource string
+}
+
+// vesselJSON is the wire form of a Vessel: one flat object with the field
+// names of the go-ais packets, as /state, state.json and the ais_data room
+// have always used.
+type vesselJSON struct {
+	UserID          uint32
+	MID             int
+	MessageID       uint8
+	RepeatIndicator uint8
+	AISClass        string `json:",omitempty"`
+	VesselStatic
+	VesselVoyage
+	VesselDynamic
+	MeteoHydro   *MeteoHydro `json:",omitempty"`
+	AreaNotice   *AreaNotice `json:",omitempty"`
+	LastUpdated  string      `json:",omitempty"`
+	Source       string      `json:",omitempty"`
+	OwnShip      bool        `json:",omitempty"`
+	MessageTypes []string    `json:",omitempty"`
+	NumMessages  int         `json:",omitempty"`
+	SignalPower  *float64    `json:",omitempty"`
+	PPM          *float64    `json:",omitempty"`
+	Channel      string      `json:",omitempty"`
+	SignalSource string      `json:",omitempty"`
+}
+
+func (v Vessel) MarshalJSON() ([]byte, error) {
+	w := vesselJSON{
+		UserID:          v.UserID,
+		MID:             v.MID,
+		MessageID:       v.MessageID,
+		RepeatIndicator: v.RepeatIndicator,
+		AISClass:        v.AISClass,
+		VesselStatic:    v.Static,
+		VesselVoyage:    v.Voyage,
+		VesselDynamic:   v.Dynamic,
+		MeteoHydro:      v.MeteoHydro,
+		AreaNotice:      v.AreaNotice,
+		Source:          v.Source,
+		OwnShip:         v.OwnShip,
+		MessageTypes:    v.MessageTypes,
+		NumMessages:     v.NumMessages,
+		SignalPower:     v.SignalPower,
+		PPM:             v.PPM,
+		Channel:         v.Channel,
+		SignalSource:    v.SignalSource,
+	}
+	if !v.LastUpdated.IsZero() {
+		w.LastUpdated = v.LastUpdated.Format(time.RFC3339Nano)
+	}
+	return json.Marshal(w)
+}
+
+func (v *Vessel) UnmarshalJSON(data []byte) error {
+	var w vesselJSON
+	if err := json.Unmarshal(data, &w); err != nil {
+		return err
+	}
+	*v = Vessel{
+		UserID:          w.UserID,
+		MID:             w.MID,
+		MessageID:       w.MessageID,
+		RepeatIndicator: w.RepeatIndicator,
+		AISClass:        w.AISClass,
+		Static:          w.VesselStatic,
+		Voyage:          w.VesselVoyage,
+		Dynamic:         w.VesselDynamic,
+		MeteoHydro:      w.MeteoHydro,
+		AreaNotice:      w.AreaNotice,
+		Source:          w.Source,
+		OwnShip:         w.OwnShip,
+		MessageTypes:    w.MessageTypes,
+		NumMessages:     w.NumMessages,
+		SignalPower:     w.SignalPower,
+		PPM:             w.PPM,
+		Channel:         w.Channel,
+		SignalSource:    w.SignalSource,
+	}
+	if w.LastUpdated != "" {
+		t, err := time.Parse(time.RFC3339Nano, w.LastUpdated)
+		if err != nil {
+			return err
+		}
+		v.LastUpdated = t
+	}
+	return nil
+}
+
+// clone returns a copy that is safe to hand out while v keeps being merged
+// into. Pointer fields are never written through, only replaced.
+func (v *Vessel) clone() *Vessel {
+	c := *v
+	c.MessageTypes = append([]string(nil), v.MessageTypes...)
+	return &c
+}
+
+// apply copies the fields a packet carries onto the vessel. Values the
+// packet marks as not available (heading 511, course 360, latitude or
+// longitude out of range) leave the previous value in place.
+func (v *Vessel) apply(packet ais.Packet) {
+	h := packet.GetHeader()
+	v.UserID = h.UserID
+	v.MessageID = h.MessageID
+	v.RepeatIndicator = h.RepeatIndicator
+	v.MID = mmsiMID(h.UserID)
+
+	s, voy, d := &v.Static, &v.Voyage, &v.Dynamic
+	switch p := packet.(type) {
+	case *ais.PositionReport:
+		d.setPosition(float64(p.Latitude), float64(p.Longitude))
+		d.setMotion(float64(p.Sog), float64(p.Cog), p.TrueHeading)
+		d.NavigationalStatus = &p.NavigationalStatus
+		d.RateOfTurn = &p.RateOfTurn
+		d.PositionAccuracy = &p.PositionAccuracy
+		d.Timestamp = &p.Timestamp
+		d.SpecialManoeuvreIndicator = &p.SpecialManoeuvreIndicator
+		d.Raim = &p.Raim
+		d.CommunicationState = &p.CommunicationState
+	case *ais.BaseStationReport:
+		d.setPosition(float64(p.Latitude), float64(p.Longitude))
+		d.BaseStationUTC = &BaseStationUTC{
+			UtcYear:   p.UtcYear,
+			UtcMonth:  p.UtcMonth,
+			UtcDay:    p.UtcDay,
+			UtcHour:   p.UtcHour,
+			UtcMinute: p.UtcMinute,
+			UtcSecond: p.UtcSecond,
+		}
+		s.FixType = &p.FixType
+		d.PositionAccuracy = &p.PositionAccuracy
+		d.LongRangeEnable = &p.LongRangeEnable
+		d.Raim = &p.Raim
+		d.CommunicationState = &p.CommunicationState
+	case *ais.ShipStaticData:
+		v.setName(p.Name, "")
+		if cs := strings.TrimSpace(p.CallSign); cs != "" {
+			s.CallSign = cs
+		}
+		s.AisVersion = &p.AisVersion
+		s.ImoNumber = &p.ImoNumber
+		s.Type = &p.Type
+		s.Dimension = &p.Dimension
+		s.FixType = &p.FixType
+		draught := float64(p.MaximumStaticDraught)
+		voy.Destination = p.Destination
+		voy.Eta = &p.Eta
+		voy.MaximumStaticDraught = &draught
+		voy.Dte = &p.Dte
+	case *ais.StandardSearchAndRescueAircraftReport:
+		d.setPosition(float64(p.Latitude), float64(p.Longitude))
+		d.setMotion(float64(p.Sog), float64(p.Cog), 511)
+		d.Altitude = &p.Altitude
+		d.AltFromBaro = &p.AltFromBaro
+		d.PositionAccuracy = &p.PositionAccuracy
+		d.Timestamp = &p.Timestamp
+		d.AssignedMode = &p.AssignedMode
+		d.Raim = &p.Raim
+		d.CommunicationStateIsItdma = &p.CommunicationStateIsItdma
+		d.CommunicationState = &p.CommunicationState
+		voy.Dte = &p.Dte
+	case *ais.StandardClassBPositionReport:
+		d.setPosition(float64(p.Latitude), float64(p.Longitude))
+		d.setMotion(float64(p.Sog), float64(p.Cog), p.TrueHeading)
+		d.PositionAccuracy = &p.PositionAccuracy
+		d.Timestamp = &p.Timestamp
+		d.AssignedMode = &p.AssignedMode
+		d.Raim = &p.Raim
+		d.CommunicationStateIsItdma = &p.CommunicationStateIsItdma
+		d.CommunicationState = &p.CommunicationState
+		s.ClassBUnit = &p.ClassBUnit
+		s.ClassBDisplay = &p.ClassBDisplay
+		s.ClassBDsc = &p.ClassBDsc
+		s.ClassBBand = &p.ClassBBand
+		s.ClassBMsg22 = &p.ClassBMsg22
+	case *ais.ExtendedClassBPositionReport:
+		d.setPosition(float64(p.Latitude), float64(p.Longitude))
+		d.setMotion(float64(p.Sog), float64(p.Cog), p.TrueHeading)
+		d.PositionAccuracy = &p.PositionAccuracy
+		d.Timestamp = &p.Timestamp
+		d.AssignedMode = &p.AssignedMode
+		d.Raim = &p.Raim
+		v.setName(p.Name, "")
+		s.Type = &p.Type
+		s.Dimension = &p.Dimension
+		s.FixType = &p.FixType
+		voy.Dte = &p.Dte
+	case *ais.AidsToNavigationReport:
+		d.setPosition(float64(p.Latitude), float64(p.Longitude))
+		d.PositionAccuracy = &p.PositionAccuracy
+		d.Timestamp = &p.Timestamp
+		d.OffPosition = &p.OffPosition
+		d.AssignedMode = &p.AssignedMode
+		d.Raim = &p.Raim
+		v.setName(p.Name, p.NameExtension)
+		s.Type = &p.Type
+		s.Dimension = &p.Dimension
+		s.FixType = &p.Fixtype
+		s.AtoN = &p.AtoN
+		s.VirtualAtoN = &p.VirtualAtoN
+	case *ais.StaticDataReport:
+		if !p.PartNumber {
+			v.setName(p.ReportA.Name, "")
+			break
+		}
+		b := p.ReportB
+		if cs := strings.TrimSpace(b.CallSign); cs != "" {
+			s.CallSign = cs
+		}
+		// Many Class B units send ship type 0 in part B; don't let it
+		// overwrite a real type.
+		if b.ShipType != 0 || s.Type == nil {
+			s.Type = &b.ShipType
+		}
+		if vendor := strings.TrimSpace(b.VendorIDName); vendor != "" {
+			s.VendorID = vendor
+		}
+		s.UnitModelCode = &b.VenderIDModel
+		s.SerialNumber = &b.VenderIDSerial
+		if isAuxiliaryCraft(h.UserID) {
+			// The 30 dimension bits hold the mothership MMSI.
+			dim := b.Dimension
+			mothership := uint32(dim.A)<<21 | uint32(dim.B)<<12 | uint32(dim.C)<<6 | uint32(dim.D)
+			s.MothershipMMSI = &mothership
+		} else {
+			s.Dimension = &b.Dimension
+		}
+		s.FixType = &b.FixType
+	case *ais.LongRangeAisBroadcastMessage:
+		d.setPosition(float64(p.Latitude), float64(p.Longitude))
+		d.setMotion(float64(p.Sog), float64(p.Cog), 511)
+		d.NavigationalStatus = &p.NavigationalStatus
+		d.PositionAccuracy = &p.PositionAccuracy
+		d.Raim = &p.Raim
+		d.PositionLatency = &p.PositionLatency
+	}
+}
+
+// applyBinary stores a decoded type 6 or 8 application message on the
+// station that sent it. app may be nil.
+func (v *Vessel) applyBinary(app *BinaryApplication) {
+	if app == nil {
+		return
+	}
+	switch {
+	case app.MeteoHydro != nil:
+		v.MeteoHydro = app.MeteoHydro
+	case app.AreaNotice != nil:
+		v.AreaNotice = app.AreaNotice
+	case app.ExtendedStatic != nil:
+		v.Static.ExtendedStatic = app.ExtendedStatic
+	case app.InlandStatic != nil:
+		v.Static.InlandStatic = app.InlandStatic
+	}
+}
+
+// setPosition stores latitude and longitude, each only if it is in range.
+func (d *VesselDynamic) setPosition(lat, lon float64) {
+	if lat >= -90 && lat <= 90 {
+		d.Latitude = &lat
+	}
+	if lon >= -180 && lon <= 180 {
+		d.Longitude = &lon
+	}
+}
+
+// setMotion stores speed, course and heading, skipping course 360 and
+// heading 511 (not available).
+func (d *VesselDynamic) setMotion(sog, cog float64, heading uint16) {
+	d.Sog = &sog
+	if cog != 360 {
+		d.Cog = &cog
+	}
+	if heading != 511 {
+		d.TrueHeading = &heading
+	}
+}
+
+// setName updates the name unless it only repeats the current one. An AtoN
+// name extension is appended to the name.
+func (v *Vessel) setName(name, ext string) {
+	name, ext = strings.TrimSpace(name), strings.TrimSpace(ext)
+	if name != "" {
+		current := strings.TrimSpace(strings.TrimSuffix(v.Static.Name, ext))
+		if current == "" || strings.ToUpper(current) == "NO NAME" || current != name {
+			v.Static.Name = name
+		}
+	}
+	if ext != "" && !strings.HasSuffix(v.Static.Name, ext) {
+		v.Static.Name += ext
+	}
+}
+
+// classify sets AISClass from the type of message just merged. Only the
+// messages a single class of station sends identify it: 1-3 and 5 (Class A),
+// 18, 19 and 24 (Class B), 4, 21 and 9. Anything else leaves the class as it
+// was, or unknown.
+func (v *Vessel) classify(msgType string) {
+	switch msgType {
+	case "ShipStaticData", "PositionReport":
+		v.AISClass = "A"
+	case "StandardClassBPositionReport", "ExtendedClassBPositionReport", "StaticDataReport":
+		v.AISClass = "B"
+	case "AidsToNavigationReport":
+		v.AISClass = "AtoN"
+	case "BaseStationReport":
+		v.AISClass = "Base Station"
+	case "StandardSearchAndRescueAircraftReport":
+		v.AISClass = "SAR"
+	}
+
+	// A station that transmits "NO NAME" is better labelled by what it is.
+	if strings.ToUpper(strings.TrimSpace(v.Static.Name)) == "NO NAME" {
+		if fallback, valid := fallbackNameForMessageType(msgType); valid {
+			v.Static.Name = fallback
+		}
+	}
+}
+
+// addMessageType records that the vessel has sent msgType.
+func (v *Vessel) addMessageType(msgType string) {
+	for _, mt := range v.MessageTypes {
+		if mt == msgType {
+			return
+		}
+	}
+	v.MessageTypes = append(v.MessageTypes, msgType)
+}
+
+// isAuxiliaryCraft reports whether mmsi is in the 98MIDxxxx range ITU-R
+// M.585 assigns to craft associated with a parent ship.
+func isAuxiliaryCraft(mmsi uint32) bool {
+	return mmsi/10000000 == 98
+}
+
+// mmsiMID returns the Maritime Identification Digits, the first three
+// digits of the MMSI.
+func mmsiMID(mmsi uint32) int {
+	for mmsi >= 1000 {
+		mmsi /= 10
+	}
+	return int(mmsi)
+}
+
+// VesselSummary is the subset of a Vessel sent in latest_vessel_summary and
+// by /summary. Fields not yet heard are null.
+type VesselSummary struct {
+	UserID               uint32
+	Name                 string
+	CallSign             string
+	ImageURL             *string
+	LastUpdated          string
+	NumMessages          int
+	Destination          *string
+	Sog                  *float64
+	Cog                  *float64
+	Type                 *uint8
+	Dimension            *ais.FieldDimension
+	MaximumStaticDraught *float64
+	NavigationalStatus   *uint8
+	Latitude             *float64
+	Longitude            *float64
+	TrueHeading          *uint16
+	AISClass             string
+	MID                  int
+	MessageTypes         []string
+}
+
+func (v *Vessel) summary() VesselSummary {
+	sum := VesselSummary{
+		UserID:               v.UserID,
+		Name:                 v.Static.Name,
+		CallSign:             v.Static.CallSign,
+		LastUpdated:          v.LastUpdated.Format(time.RFC3339Nano),
+		NumMessages:          v.NumMessages,
+		Sog:                  v.Dynamic.Sog,
+		Cog:                  v.Dynamic.Cog,
+		Type:                 v.Static.Type,
+		Dimension:            v.Static.Dimension,
+		MaximumStaticDraught: v.Voyage.MaximumStaticDraught,
+		NavigationalStatus:   v.Dynamic.NavigationalStatus,
+		Latitude:             v.Dynamic.Latitude,
+		Longitude:            v.Dynamic.Longitude,
+		TrueHeading:          v.Dynamic.TrueHeading,
+		AISClass:             v.AISClass,
+		MID:                  v.MID,
+		MessageTypes:         v.MessageTypes,
+	}
+	if v.Static.ImageURL != "" {
+		sum.ImageURL = &v.Static.ImageURL
+	}
+	if v.Voyage.Destination != "" {
+		sum.Destination = &v.Voyage.Destination
+	}
+	return sum
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zishang520/socket.io/v2/socket"
+)
+
+// weatherDirName is the directory under the state dir holding one CSV of
+// DAC 1 FI 31 observations per station, next to history/.
+const weatherDirName = "weather"
+
+// weatherRoom receives every observation as it arrives.
+const weatherRoom = "weather"
+
+// weatherColumns is the layout of the weather CSVs, also sent as the first
+// line by /weather/<mmsi>/<hours>. Units are those of MeteoHydro.
+var weatherColumns = []string{
+	"timestamp", "latitude", "longitude",
+	"wind_speed", "wind_gust", "wind_direction", "wind_gust_direction",
+	"air_temperature", "relative_humidity", "dew_point",
+	"air_pressure", "air_pressure_tendency", "visibility",
+	"water_level", "water_level_trend", "current_speed", "current_direction",
+	"wave_height", "wave_period", "wave_direction",
+	"swell_height", "swell_period", "swell_direction",
+	"sea_state", "water_temperature",
+}
+
+// WeatherObservation is one FI 31 report as pushed to the weather room.
+type WeatherObservation struct {
+	UserID     uint32
+	Name       string `json:",omitempty"`
+	ObservedAt time.Time
+	Latitude   *float64 `json:",omitempty"` // sensor position, else the station's
+	Longitude  *float64 `json:",omitempty"`
+	MeteoHydro *MeteoHydro
+}
+
+// observedAt completes the day/hour/minute of the report from the time it
+// was received. A report with no time is taken to be current. It reports
+// false if the day fits neither this month nor the previous one.
+func (m *MeteoHydro) observedAt(received time.Time) (time.Time, bool) {
+	received = received.UTC()
+	if m.Day == 0 || m.Hour == nil || m.Minute == nil {
+		return received, true
+	}
+	day := int(m.Day)
+	at := func(month time.Month) (time.Time, bool) {
+		t := time.Date(received.Year(), month, day, int(*m.Hour), int(*m.Minute), 0, 0, time.UTC)
+		// time.Date moves the 31st of a 30-day month into the next month.
+		return t, t.Day() == day
+	}
+	// A day of month ahead of today belongs to the previous month.
+	if t, ok := at(received.Month()); ok && t.Sub(received) <= 24*time.Hour {
+		return t, true
+	}
+	return at(received.Month() - 1)
+}
+
+// newWeatherObservation builds the observation carried by msg, or returns
+// nil if msg is not an FI 31 report or its date is impossible.
+func newWeatherObservation(msg *DecodedMessage) *WeatherObservation {
+	if msg.Application == nil || msg.Application.MeteoHydro == nil {
+		return nil
+	}
+	m := msg.Application.MeteoHydro
+	observedAt, ok := m.observedAt(msg.ReceivedAt)
+	if !ok {
+		return nil
+	}
+	obs := &WeatherObservation{
+		UserID:     msg.Data.UserID,
+		ObservedAt: observedAt,
+		Latitude:   m.Latitude,
+		Longitude:  m.Longitude,
+		MeteoHydro: m,
+	}
+	if v := msg.Vessel; v != nil {
+		obs.Name = v.Static.Name
+		if obs.Latitude == nil || obs.Longitude == nil {
+			obs.Latitude, obs.Longitude = v.Dynamic.Latitude, v.Dynamic.Longitude
+		}
+	}
+	return obs
+}
+
+// csvRecord renders the observation as a line of the weather CSV.
+func (obs *WeatherObservation) csvRecord() string {
+	m := obs.MeteoHydro
+	f := func(v *float64) string {
+		if v == nil {
+			return ""
+		}
+		return strconv.FormatFloat(*v, 'f', -1, 64)
+	}
+	c := func(v *uint8) string {
+		if v == nil {
+			return ""
+		}
+		return strconv.Itoa(int(*v))
+	}
+	lat, lon := "", ""
+	if obs.Latitude != nil && obs.Longitude != nil {
+		lat, lon = fmt.Sprintf("%.6f", *obs.Latitude), fmt.Sprintf("%.6f", *obs.Longitude)
+	}
+	fields := []string{
+		obs.ObservedAt.Format(time.RFC3339Nano), lat, lon,
+		f(m.WindSpeed), f(m.WindGust), f(m.WindDirection), f(m.WindGustDirection),
+		f(m.AirTemperature), f(m.RelativeHumidity), f(m.DewPoint),
+		f(m.AirPressure), c(m.AirPressureTendency), f(m.Visibility),
+		f(m.WaterLevel), c(m.WaterLevelTrend), f(m.CurrentSpeed), f(m.CurrentDirection),
+		f(m.WaveHeight), f(m.WavePeriod), f(m.WaveDirection),
+		f(m.SwellHeight), f(m.SwellPeriod), f(m.SwellDirection),
+		c(m.SeaState), f(m.WaterTemperature),
+	}
+	return strings.Join(fields, ",")
+}
+
+// appendWeather appends an observation to the station's weather CSV.
+func appendWeather(baseDir string, obs *WeatherObservation) error {
+	weatherDir := filepath.Join(baseDir, weatherDirName)
+	if err := os.MkdirAll(weatherDir, 0755); err != nil {
+		return err
+	}
+	filePath := filepath.Join(weatherDir, strconv.FormatUint(uint64(obs.UserID), 10)+".csv")
+	f, err := os.OpenFile(filePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.WriteString(obs.csvRecord() + "\n")
+	return err
+}
+
+// latestWeather returns the last observation of every station that has
+// sent one, for a map drawing current conditions.
+func latestWeather() []*WeatherObservation {
+	vesselDataMutex.Lock()
+	defer vesselDataMutex.Unlock()
+	out := make([]*WeatherObservation, 0)
+	for _, v := range vesselData {
+		m := v.MeteoHydro
+		if m == nil {
+			continue
+		}
+		observedAt, ok := m.observedAt(v.LastUpdated)
+		if !ok {
+			continue
+		}
+		obs := &WeatherObservation{
+			UserID:     v.UserID,
+			Name:       v.Static.Name,
+			ObservedAt: observedAt,
+			Latitude:   m.Latitude,
+			Longitude:  m.Longitude,
+			MeteoHydro: m,
+		}
+		if obs.Latitude == nil || obs.Longitude == nil {
+			obs.Latitude, obs.Longitude = v.Dynamic.Latitude, v.Dynamic.Longitude
+		}
+		out = append(out, obs)
+	}
+	return out
+}
+
+// weatherSink records FI 31 observations per station and pushes them to
+// the weather room.
+type weatherSink struct {
+	baseDir string
+	noState bool
+	server  *socket.Server
+}
+
+func (s *weatherSink) RawSentence(msg *Sentence) {}
+
+func (s *weatherSink) Decoded(msg *DecodedMessage) {
+	obs := newWeatherObservation(msg)
+	if obs == nil {
+		return
+	}
+	if !s.noState {
+		if err := appendWeather(s.baseDir, obs); err != nil {
+			log.Printf("Error appending weather for station %d: %v", obs.UserID, err)
+		}
+	}
+	data, err := json.Marshal(obs)
+	if err != nil {
+		log.Printf("Error marshaling weather observation: %v", err)
+		return
+	}
+	if err := s.server.To(socket.Room(weatherRoom)).Emit("weather", string(data)); err != nil {
+		log.Printf("Error sending weather observation to room %s: %v", weatherRoom, err)
+	}
+}